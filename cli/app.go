@@ -1,10 +1,10 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -15,11 +15,36 @@ import (
 // Deps are an application dependencies.
 type Deps struct {
 	store store.Store
+	// cache is the store cache persisted at cacheFile, if any.
+	cache     *store.CachedStore
+	cacheFile string
+	// quota accounts for the calls of each API key, if any.
+	quota *store.Quota
+}
+
+// saveCache persists the store cache, if any.
+func (d *Deps) saveCache() error {
+	if d.cache == nil {
+		return nil
+	}
+	return d.cache.Save(d.cacheFile)
+}
+
+// closeQuota saves the calls performed with each API key, if accounted for.
+func (d *Deps) closeQuota() error {
+	if d.quota == nil {
+		return nil
+	}
+	return d.quota.Close()
 }
 
 // App provides methods for reading datasets and performing weather queries.
 type App struct {
 	deps *Deps
+	// columns maps dataset fields to columns, nil for the default layout of each format.
+	columns columnMapping
+	// input tells how datasets are encoded.
+	input datasetInput
 }
 
 // NewApp using dependencies/
@@ -27,115 +52,301 @@ func NewApp(deps *Deps) *App {
 	return &App{deps: deps}
 }
 
-// LoadAirportsDataset from source file and returns full list of found airports (including duplicates).
-func (a *App) LoadAirportsDataset(src string) ([]store.Airport, error) {
-	log.Printf("loading airports from %s", src)
-	rows, err := loadCSV(src)
+// LoadAirportsDataset from source file and returns full list of found airports (including duplicates),
+// along with the dataset row each one was read from.
+func (a *App) LoadAirportsDataset(src string) ([]store.Airport, []int, error) {
+	log.Printf("loading airports from %s", datasetName(src))
+	header, rows, numbers, err := loadDataset(src, a.input)
+	if err != nil {
+		return nil, nil, err
+	}
+	layout, err := a.layout(airportDatasetFormat, header)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	airports := make([]store.Airport, (len(rows))*2)
+	airports := make([]store.Airport, 0, len(rows)*2)
+	airportRows := make([]int, 0, len(rows)*2)
 	unique := make(map[string]bool)
 	for i, row := range rows {
-		if len(row) < 6 {
-			return nil, fmt.Errorf("missing columns at row %d, got %d", i+2, len(row))
+		pair, err := parseAirportsRow(layout, row, numbers[i])
+		if err != nil {
+			return nil, nil, err
 		}
-		coords := map[int]float64{2: 0.0, 3: 0.0, 4: 0.0, 5: 0.0}
-		for k := range coords {
-			c, err := strconv.ParseFloat(row[k], 64)
-			if err != nil {
-				var coordType string
-				if k%2 == 0 {
-					coordType = "lat"
-				} else {
-					coordType = "lon"
-				}
-				return nil, fmt.Errorf("got invalid coordinates (%s) value %q for airport code %q at row %d", coordType, row[k], row[(k/2)-1], i+2)
-			}
-			coords[k] = c
+		for _, airport := range pair {
+			airports = append(airports, airport)
+			airportRows = append(airportRows, numbers[i])
+			unique[airport.Code] = true
 		}
-		airports[i*2] = store.Airport{Code: strings.Trim(row[0], ""), Latitude: coords[2], Longitude: coords[3]}
-		airports[i*2+1] = store.Airport{Code: strings.Trim(row[1], ""), Latitude: coords[4], Longitude: coords[5]}
-		if airports[i*2].Code == "" {
-			return nil, fmt.Errorf("got empty airport code (origin) at row %d", i+2)
+	}
+	log.Printf("\t✅  loaded %d airports (%d unique)", len(airports), len(unique))
+	return airports, airportRows, nil
+}
+
+// layout returns the layout of a dataset of the given format and header according to the app
+// column mapping, or the default one of the format if none was set.
+func (a *App) layout(format datasetFormat, header []string) (datasetLayout, error) {
+	if a.columns == nil {
+		return defaultLayout(format), nil
+	}
+	return a.columns.resolve(header)
+}
+
+// parseAirportsRow returns the origin and destination airports of an airports dataset row.
+func parseAirportsRow(l datasetLayout, row []string, line int) ([2]store.Airport, error) {
+	var pair [2]store.Airport
+	if len(row) < l.columns() {
+		return pair, fmt.Errorf("missing columns at row %d, got %d", line, len(row))
+	}
+	fields := [2][3]datasetField{
+		{originField, originLatField, originLonField},
+		{destinationField, destinationLatField, destinationLonField},
+	}
+	for i, f := range fields {
+		code := strings.TrimSpace(l.get(row, f[0]))
+		if code == "" {
+			return pair, fmt.Errorf("got empty airport code (%s) at row %d", datasetFieldNames[f[0]], line)
 		}
-		if airports[i*2+1].Code == "" {
-			return nil, fmt.Errorf("got empty airport code (destination) at row %d", i+2)
+		var coords [2]float64
+		for j, coordType := range []string{"lat", "lon"} {
+			c, err := strconv.ParseFloat(l.get(row, f[j+1]), 64)
+			if err != nil {
+				return pair, fmt.Errorf("got invalid coordinates (%s) value %q for airport code %q at row %d", coordType, l.get(row, f[j+1]), code, line)
+			}
+			coords[j] = c
 		}
-		unique[airports[i*2].Code] = true
-		unique[airports[i*2+1].Code] = true
+		pair[i] = store.Airport{Code: code, Latitude: coords[0], Longitude: coords[1]}
 	}
-	log.Printf("\t✅  loaded %d airports (%d unique)", len(airports), len(unique))
-	return airports, nil
+	return pair, nil
 }
 
-// LoadCitiesDataset from source file and returns full list of found city names (including duplicates).
-func (a *App) LoadCitiesDataset(src string) ([]string, error) {
-	log.Printf("loading cities from %s", src)
-	rows, err := loadCSV(src)
+// LoadCitiesDataset from source file and returns full list of found city names (including duplicates),
+// along with the dataset row each one was read from.
+func (a *App) LoadCitiesDataset(src string) ([]string, []int, error) {
+	log.Printf("loading cities from %s", datasetName(src))
+	header, rows, numbers, err := loadDataset(src, a.input)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	layout, err := a.layout(citiesDatasetFormat, header)
+	if err != nil {
+		return nil, nil, err
 	}
 	cities := make([]string, len(rows))
 	unique := make(map[string]bool)
 	for i, row := range rows {
-		if len(row) < 1 {
-			return nil, fmt.Errorf("missing columns at row %d", i+2)
-		}
-		cities[i] = strings.Trim(row[0], " \n")
-		if cities[i] == "" {
-			return nil, fmt.Errorf("got empty city name at row %d", i+2)
+		if cities[i], err = parseCitiesRow(layout, row, numbers[i]); err != nil {
+			return nil, nil, err
 		}
 		unique[cities[i]] = true
 	}
 	log.Printf("\t✅  loaded %d cities (%d unique)", len(cities), len(unique))
-	return cities, nil
+	return cities, numbers, nil
 }
 
-func (a *App) GetAirportsWeather(airports []store.Airport) (map[string]store.WeatherReport, error) {
+// parseCitiesRow returns the city name of a cities dataset row.
+func parseCitiesRow(l datasetLayout, row []string, line int) (string, error) {
+	if len(row) < l.columns() {
+		return "", fmt.Errorf("missing columns at row %d", line)
+	}
+	city := strings.Trim(l.get(row, cityField), " \n")
+	if city == "" {
+		return "", fmt.Errorf("got empty city name at row %d", line)
+	}
+	return city, nil
+}
+
+// GetAirportsWeather returns the weather of the given airports read from the given dataset rows, as
+// loaded by LoadAirportsDataset, in dataset order.
+func (a *App) GetAirportsWeather(ctx context.Context, airports []store.Airport, rows []int) (*store.ResultSet, error) {
 	log.Print("\nfetching weather information...")
 	start := time.Now()
-	results := a.deps.store.GetWeatherByAirportCode(airports)
+	reports := a.deps.store.GetWeatherByAirportCode(ctx, airports)
 	elapsed := time.Since(start)
+	queries := make([]store.Query, len(airports))
+	for i, airport := range airports {
+		queries[i] = store.Query{Key: airport.Code, Row: rows[i]}
+	}
+	results := store.NewResultSet(queries, reports)
 	printReport(results, elapsed)
+	printUsage(a.deps.store.GetAPIUsage())
 	return results, nil
 }
 
-func (a *App) GetCitiesWeather(cities []string) (map[string]store.WeatherReport, error) {
+// GetCitiesWeather returns the weather of the given cities read from the given dataset rows, as
+// loaded by LoadCitiesDataset, in dataset order.
+func (a *App) GetCitiesWeather(ctx context.Context, cities []string, rows []int) (*store.ResultSet, error) {
 	log.Print("\nfetching weather information...")
 	start := time.Now()
-	results := a.deps.store.GetWeatherByCityName(cities)
+	reports := a.deps.store.GetWeatherByCityName(ctx, cities)
 	elapsed := time.Since(start)
+	queries := make([]store.Query, len(cities))
+	for i, city := range cities {
+		queries[i] = store.Query{Key: city, Row: rows[i]}
+	}
+	results := store.NewResultSet(queries, reports)
 	printReport(results, elapsed)
+	printUsage(a.deps.store.GetAPIUsage())
 	return results, nil
 }
 
-func printReport(results map[string]store.WeatherReport, elapsed time.Duration) {
+// PlanAirportsWeather logs the work required to fetch the weather of the given airports without
+// calling the API.
+func (a *App) PlanAirportsWeather(airports []store.Airport) error {
+	planner, ok := a.deps.store.(store.Planner)
+	if !ok {
+		return fmt.Errorf("store does not support dry runs")
+	}
+	printPlan(planner.PlanWeatherByAirportCode(airports))
+	return nil
+}
+
+// PlanCitiesWeather logs the work required to fetch the weather of the given cities without
+// calling the API.
+func (a *App) PlanCitiesWeather(cities []string) error {
+	planner, ok := a.deps.store.(store.Planner)
+	if !ok {
+		return fmt.Errorf("store does not support dry runs")
+	}
+	printPlan(planner.PlanWeatherByCityName(cities))
+	return nil
+}
+
+func printPlan(p store.Plan) {
+	log.Print("\ndry run, no API calls will be performed")
+	log.Printf("\tqueries: %d (%d unique)", p.Queries, p.Unique)
+	log.Printf("\tcached: %d", p.Cached)
+	log.Printf("\tAPI calls: %d", p.Calls)
+	log.Printf("\tbatches: %d (up to %d calls/minute)", p.Batches, p.RequestsPerMinute)
+	log.Printf("\testimated time: %s", p.EstimatedDuration)
+}
+
+func printReport(results *store.ResultSet, elapsed time.Duration) {
+	var summary resultsSummary
+	for _, res := range results.Unique() {
+		summary.add(res.Report)
+	}
+	summary.queries = results.Len()
+	summary.print(elapsed)
+}
+
+// resultsSummary counts results by outcome.
+type resultsSummary struct {
+	// queries is the number of queries answered, duplicates included.
+	queries                                         int
+	success, failed, notAttempted, notCached, stale uint
+}
+
+// add counts the report of a distinct query.
+func (s *resultsSummary) add(r store.WeatherReport) {
+	if r.Stale {
+		s.stale++
+	}
+	switch {
+	case r.NotAttempted:
+		s.notAttempted++
+	case r.NotCached:
+		s.notCached++
+	case r.Failed:
+		s.failed++
+	default:
+		s.success++
+	}
+}
+
+// print logs the summary of results obtained in the given elapsed time.
+func (s *resultsSummary) print(elapsed time.Duration) {
 	log.Printf("\t✅  DONE")
-	log.Printf("\tresults: %d", len(results))
+	log.Printf("\tresults: %d (%d queries)", s.success+s.failed+s.notAttempted+s.notCached, s.queries)
 	log.Printf("\telapsed time: %s", elapsed)
+	log.Printf("\tsucessful: %d", s.success)
+	log.Printf("\tfailed: %d", s.failed)
+	if s.stale > 0 {
+		log.Printf("\tstale: %d (observations older than -stale-after)", s.stale)
+	}
+	if s.notAttempted > 0 {
+		log.Printf("\tnot attempted: %d", s.notAttempted)
+	}
+	if s.notCached > 0 {
+		log.Printf("\tnot cached: %d (run without -offline to fetch them)", s.notCached)
+	}
+}
 
-	var success, failed uint
-	for _, r := range results {
-		if r.Failed {
-			failed++
-		} else {
-			success++
+// notAttempted returns the queries that were never attempted, e.g: because the run was
+// interrupted, in dataset order.
+func notAttempted(results *store.ResultSet) []string {
+	var keys []string
+	for _, r := range results.Unique() {
+		if r.Report.NotAttempted {
+			keys = append(keys, r.Key)
 		}
 	}
-	log.Printf("\tsucessful: %d", success)
-	log.Printf("\tfailed: %d", failed)
+	return keys
 }
 
-func loadCSV(src string) ([][]string, error) {
-	file, err := os.Open(src)
+// printNotAttempted logs the given queries that were never attempted.
+func printNotAttempted(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	log.Printf("\n⚠️  %d queries were never attempted:", len(keys))
+	for _, k := range keys {
+		log.Printf("\t%s", k)
+	}
+}
+
+// printUsage logs the API request rate and usage statistics of each key.
+func printUsage(usage store.APIUsage) {
+	if usage.RequestsPerMinute > 0 {
+		log.Printf("\trate: %d calls/minute", usage.RequestsPerMinute)
+	}
+	if usage.CoalescedRequests > 0 {
+		log.Printf("\tcoalesced: %d (answered by calls already in flight)", usage.CoalescedRequests)
+	}
+	if usage.ShortCircuited > 0 {
+		log.Printf("\tshort-circuited: %d (failed by the circuit breaker without calling the API)", usage.ShortCircuited)
+	}
+	if c := usage.Cache; c != nil {
+		log.Printf("\tcache: %d hits, %d misses, %d evictions (%d entries)", c.Hits, c.Misses, c.Evictions, c.Entries)
+	}
+	if len(usage.Keys) < 2 {
+		return
+	}
+	log.Printf("\tAPI keys usage:")
+	for _, k := range usage.Keys {
+		status := "active"
+		if k.Disabled {
+			status = "disabled"
+		}
+		log.Printf("\t\tkey %s (%s): %d successful, %d failed, %d rate-limited", k.KeyID, status, k.SuccessfulCalls, k.FailedCalls, k.RateLimitedCalls)
+	}
+}
+
+// loadDataset returns the header and rows of the dataset at src, along with the number of each row,
+// see rowReader.Row.
+func loadDataset(src string, in datasetInput) ([]string, [][]string, []int, error) {
+	reader, err := openDataset(src, in)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+	defer reader.Close()
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil, nil
 	}
-	reader := csv.NewReader(file)
-	data, err := reader.ReadAll()
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+	var rows [][]string
+	var numbers []int
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return header, rows, numbers, nil
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		rows = append(rows, row)
+		numbers = append(numbers, reader.Row())
 	}
-	return data[1:], nil
 }
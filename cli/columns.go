@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// datasetField is a piece of information read from dataset rows.
+type datasetField int
+
+const (
+	originField datasetField = iota
+	destinationField
+	originLatField
+	originLonField
+	destinationLatField
+	destinationLonField
+	cityField
+	departureField
+	numDatasetFields
+)
+
+// datasetFieldNames are the names of the fields in column mapping specs.
+var datasetFieldNames = [numDatasetFields]string{"origin", "destination", "origin_lat", "origin_lon", "destination_lat", "destination_lon", "city", "departure"}
+
+// datasetFieldLabels are the human readable names of the fields.
+var datasetFieldLabels = [numDatasetFields]string{"origin airport code", "destination airport code", "origin lat", "origin lon", "destination lat", "destination lon", "city name", "departure"}
+
+func (f datasetField) String() string {
+	return datasetFieldLabels[f]
+}
+
+// airportFields are the fields required by airports datasets.
+var airportFields = []datasetField{originField, destinationField, originLatField, originLonField, destinationLatField, destinationLonField}
+
+// columnMapping maps dataset fields to the columns holding them, either header names (compared
+// ignoring case and surrounding spaces) or 1-based positions prefixed with #, e.g: #3.
+type columnMapping map[datasetField]string
+
+// defaultColumnMappings are the column mappings of each format when none is given, addressing
+// columns by position as documented in the README.
+var defaultColumnMappings = map[datasetFormat]columnMapping{
+	airportDatasetFormat: {originField: "#1", destinationField: "#2", originLatField: "#3", originLonField: "#4", destinationLatField: "#5", destinationLonField: "#6"},
+	citiesDatasetFormat:  {cityField: "#1"},
+}
+
+// columnMappingUsage is the usage of the column mapping flags.
+const columnMappingUsage = "comma separated FIELD=COLUMN pairs naming the column of each field, by header name or #position, " +
+	"e.g: origin=From,destination=To,origin_lat=#3,origin_lon=#4,destination_lat=#5,destination_lon=#6 (fields: " +
+	"origin, destination, origin_lat, origin_lon, destination_lat, destination_lon, city, departure)"
+
+// parseColumnMapping parses a FIELD=COLUMN spec, pairs are separated by commas or new lines and
+// lines starting with # are ignored.
+func parseColumnMapping(spec string) (columnMapping, error) {
+	m := make(columnMapping)
+	for _, line := range strings.Split(spec, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, pair := range strings.Split(line, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+				return nil, fmt.Errorf("got invalid column mapping %q, want FIELD=COLUMN", strings.TrimSpace(pair))
+			}
+			field, ok := datasetFieldByName(strings.TrimSpace(parts[0]))
+			if !ok {
+				return nil, fmt.Errorf("got unknown field %q in column mapping, use one of %s", strings.TrimSpace(parts[0]), strings.Join(datasetFieldNames[:], ", "))
+			}
+			if _, ok := m[field]; ok {
+				return nil, fmt.Errorf("got field %q mapped more than once", datasetFieldNames[field])
+			}
+			m[field] = strings.TrimSpace(parts[1])
+		}
+	}
+	if _, err := m.format(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// registerColumnsFlags defines column mapping flags on fs and returns a function that returns the
+// mapping once fs is parsed, nil if none was given.
+func registerColumnsFlags(fs *flag.FlagSet) func() (columnMapping, error) {
+	var spec, file string
+	fs.StringVar(&spec, "columns", "", "columns holding each field, by default they're read by position: "+columnMappingUsage)
+	fs.StringVar(&file, "columns-file", "", "file holding the -columns mapping, one or more FIELD=COLUMN pairs per line")
+	return func() (columnMapping, error) {
+		switch {
+		case spec != "" && file != "":
+			return nil, fmt.Errorf("cannot use both -columns and -columns-file")
+		case spec != "":
+			return parseColumnMapping(spec)
+		case file != "":
+			return readColumnMapping(file)
+		}
+		return nil, nil
+	}
+}
+
+// readColumnMapping parses the column mapping spec at path, see parseColumnMapping.
+func readColumnMapping(path string) (columnMapping, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading column mapping: %v", err)
+	}
+	return parseColumnMapping(string(content))
+}
+
+func datasetFieldByName(name string) (datasetField, bool) {
+	for f, n := range datasetFieldNames {
+		if strings.EqualFold(n, name) {
+			return datasetField(f), true
+		}
+	}
+	return 0, false
+}
+
+// format returns the dataset format described by the mapping: airports if every airport field is
+// mapped, cities if the city is.
+func (m columnMapping) format() (datasetFormat, error) {
+	var airports int
+	for _, f := range airportFields {
+		if _, ok := m[f]; ok {
+			airports++
+		}
+	}
+	_, city := m[cityField]
+	switch {
+	case airports > 0 && city:
+		return unknownDatasetFormat, fmt.Errorf("column mapping mixes airport and city fields, map either %s or city", strings.Join(datasetFieldNames[:len(airportFields)], ", "))
+	case airports == len(airportFields):
+		return airportDatasetFormat, nil
+	case airports > 0:
+		return unknownDatasetFormat, fmt.Errorf("column mapping misses airport fields, map every one of %s", strings.Join(datasetFieldNames[:len(airportFields)], ", "))
+	case city:
+		return citiesDatasetFormat, nil
+	}
+	return unknownDatasetFormat, fmt.Errorf("column mapping maps neither airports nor cities fields")
+}
+
+// datasetLayout holds the index of the column of each field within dataset rows, -1 for fields
+// that are not mapped.
+type datasetLayout [numDatasetFields]int
+
+// resolve returns the layout of a dataset with the given header according to the mapping.
+func (m columnMapping) resolve(header []string) (datasetLayout, error) {
+	var l datasetLayout
+	for f := range l {
+		l[f] = -1
+	}
+	for f, ref := range m {
+		i, err := columnIndex(header, ref)
+		if err != nil {
+			return l, fmt.Errorf("failed mapping %s: %v", datasetFieldNames[f], err)
+		}
+		l[f] = i
+	}
+	return l, nil
+}
+
+// columnIndex returns the index of the column referenced by ref within header.
+func columnIndex(header []string, ref string) (int, error) {
+	if strings.HasPrefix(ref, "#") {
+		n, err := strconv.Atoi(ref[1:])
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("got invalid column position %q, want #N with N starting at 1", ref)
+		}
+		return n - 1, nil
+	}
+	for i, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), ref) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("column %q not found in header %q", ref, strings.Join(header, ","))
+}
+
+// columns returns the number of columns rows need to hold every mapped field.
+func (l datasetLayout) columns() int {
+	n := 0
+	for _, i := range l {
+		if i+1 > n {
+			n = i + 1
+		}
+	}
+	return n
+}
+
+// get returns the value of field f in row, or empty if the field isn't mapped or the row is
+// missing the column.
+func (l datasetLayout) get(row []string, f datasetField) string {
+	if i := l[f]; i >= 0 && i < len(row) {
+		return row[i]
+	}
+	return ""
+}
+
+// column returns the 1-based position of the column of field f, zero if not mapped.
+func (l datasetLayout) column(f datasetField) int {
+	return l[f] + 1
+}
+
+// defaultLayout returns the layout of datasets of the given format without a column mapping.
+func defaultLayout(format datasetFormat) datasetLayout {
+	// Default mappings only address columns by position, they always resolve.
+	l, _ := defaultColumnMappings[format].resolve(nil)
+	return l
+}
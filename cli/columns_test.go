@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pablotrinidad/weatherreport/store"
+)
+
+func TestParseColumnMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    columnMapping
+		wantErr string
+	}{
+		{
+			name: "cities",
+			spec: "city=Destination, departure=#3",
+			want: columnMapping{cityField: "Destination", departureField: "#3"},
+		},
+		{
+			name: "airports file",
+			spec: "# flights export\norigin=From,destination=To\nORIGIN_LAT=#3\norigin_lon=#4\ndestination_lat=#5\ndestination_lon=#6\n",
+			want: columnMapping{originField: "From", destinationField: "To", originLatField: "#3", originLonField: "#4", destinationLatField: "#5", destinationLonField: "#6"},
+		},
+		{
+			name:    "missing column",
+			spec:    "city",
+			wantErr: "want FIELD=COLUMN",
+		},
+		{
+			name:    "unknown field",
+			spec:    "town=#1",
+			wantErr: `unknown field "town"`,
+		},
+		{
+			name:    "repeated field",
+			spec:    "city=#1,city=#2",
+			wantErr: "mapped more than once",
+		},
+		{
+			name:    "partial airports",
+			spec:    "origin=#1,destination=#2",
+			wantErr: "misses airport fields",
+		},
+		{
+			name:    "mixed formats",
+			spec:    "city=#1,origin=#2,destination=#3,origin_lat=#4,origin_lon=#5,destination_lat=#6,destination_lon=#7",
+			wantErr: "mixes airport and city fields",
+		},
+		{
+			name:    "no format",
+			spec:    "departure=#1",
+			wantErr: "maps neither",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseColumnMapping(test.spec)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("parseColumnMapping returned error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseColumnMapping returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("got mapping %v, want %v\ndiff: got->want %s", got, test.want, diff)
+			}
+		})
+	}
+}
+
+func TestColumnMapping_resolve(t *testing.T) {
+	header := []string{"Departs", " City Name ", "Notes"}
+	tests := []struct {
+		name    string
+		mapping columnMapping
+		want    map[datasetField]int
+		wantErr string
+	}{
+		{
+			name:    "by name ignoring case",
+			mapping: columnMapping{cityField: "city name", departureField: "DEPARTS"},
+			want:    map[datasetField]int{cityField: 1, departureField: 0},
+		},
+		{
+			name:    "by position",
+			mapping: columnMapping{cityField: "#3"},
+			want:    map[datasetField]int{cityField: 2},
+		},
+		{
+			name:    "unknown name",
+			mapping: columnMapping{cityField: "Town"},
+			wantErr: `column "Town" not found`,
+		},
+		{
+			name:    "invalid position",
+			mapping: columnMapping{cityField: "#0"},
+			wantErr: "invalid column position",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, err := test.mapping.resolve(header)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("resolve returned error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolve returned unexpected error: %v", err)
+			}
+			for f := datasetField(0); f < numDatasetFields; f++ {
+				want, ok := test.want[f]
+				if !ok {
+					want = -1
+				}
+				if l[f] != want {
+					t.Errorf("got %s at column index %d, want %d", f, l[f], want)
+				}
+			}
+		})
+	}
+}
+
+func TestApp_LoadAirportsDataset_columns(t *testing.T) {
+	app := NewApp(&Deps{})
+	app.columns = columnMapping{originField: "from", destinationField: "to", originLatField: "from lat", originLonField: "from lon", destinationLatField: "to lat", destinationLonField: "to lon"}
+	src := writeDataset(t, "flight,to,to lat,to lon,from,from lat,from lon\n"+
+		"AM100,MEX,19.4363,-99.0721,TLC,19.3371,-99.566\n")
+
+	got, rows, err := app.LoadAirportsDataset(src)
+	if err != nil {
+		t.Fatalf("LoadAirportsDataset returned unexpected error: %v", err)
+	}
+	want := []store.Airport{
+		{Code: "TLC", Latitude: 19.3371, Longitude: -99.566},
+		{Code: "MEX", Latitude: 19.4363, Longitude: -99.0721},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("got airports %v, want %v\ndiff: got->want %s", got, want, diff)
+	}
+	if !cmp.Equal(rows, []int{2, 2}) {
+		t.Errorf("got rows %v, want [2 2]", rows)
+	}
+}
@@ -3,16 +3,36 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 type Config struct {
-	openweatherAPIKey string
+	// openweatherAPIKeys are the OpenWeather API keys whose quota is pooled together.
+	openweatherAPIKeys []string
+	// language of weather descriptions, empty for OpenWeather's default (English).
+	language string
 }
 
-func getConfig() (*Config, error) {
-	owAPIKey, ok := os.LookupEnv("OPENWEATHER_API_KEY")
-	if !ok {
-		return nil, fmt.Errorf("missing OpenWeather API key, please set envar OPENWEATHER_API_KEY to continue")
+// getConfig reads the API keys from envars OPENWEATHER_API_KEYS (comma-separated list) and
+// OPENWEATHER_API_KEY (single key). At least one key is required unless running offline.
+func getConfig(opts *options) (*Config, error) {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, k := range strings.Split(os.Getenv("OPENWEATHER_API_KEYS")+","+os.Getenv("OPENWEATHER_API_KEY"), ",") {
+		k = strings.TrimSpace(k)
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		keys = append(keys, k)
 	}
-	return &Config{openweatherAPIKey: owAPIKey}, nil
+	if len(keys) == 0 && !opts.offline {
+		return nil, fmt.Errorf("missing OpenWeather API key, please set envar OPENWEATHER_API_KEY (or OPENWEATHER_API_KEYS for a comma-separated list of keys) to continue")
+	}
+	return &Config{openweatherAPIKeys: keys, language: opts.lang}, nil
+}
+
+// secrets returns the configuration values that must never be written to any output.
+func (c *Config) secrets() []string {
+	return c.openweatherAPIKeys
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// datasetFormatUsage is the usage of the dataset format flags.
+const datasetFormatUsage = "dataset format [1,2], detected from the dataset header and first rows by default:\n\t1: Airport codes dataset\n\t2: City names dataset"
+
+// detectionSampleRows is the number of rows after the header whose content is checked when the
+// header doesn't tell the dataset format.
+const detectionSampleRows = 10
+
+func (f datasetFormat) String() string {
+	switch f {
+	case airportDatasetFormat:
+		return "airports (-f 1)"
+	case citiesDatasetFormat:
+		return "city names (-f 2)"
+	}
+	return "unknown"
+}
+
+// Known header column names, compared lowercased and without surrounding spaces.
+var (
+	airportCodeHeaders  = map[string]bool{"origin": true, "destination": true, "origen": true, "destino": true, "origin_code": true, "destination_code": true}
+	coordinateHeaderPre = []string{"origin_", "destination_", "origen_", "destino_"}
+	cityHeaders         = map[string]bool{"city": true, "city name": true, "city_name": true, "ciudad": true, "destino": true, "destination": true}
+)
+
+// resolveDatasetFormat returns the format of the dataset at src. Datasets with a column mapping have
+// the format of the fields it maps. Otherwise, unless given, i.e: format is unknown, it's detected
+// from the dataset header and first rows. Formats given that don't match the detected one are used
+// anyway, with a warning.
+func resolveDatasetFormat(src string, in datasetInput, format datasetFormat, columns columnMapping) (datasetFormat, error) {
+	if columns != nil {
+		mapped, err := columns.format()
+		if err != nil {
+			return unknownDatasetFormat, err
+		}
+		if format != unknownDatasetFormat && format != mapped {
+			return unknownDatasetFormat, fmt.Errorf("got dataset format %s but the column mapping is for format %s", format, mapped)
+		}
+		return mapped, nil
+	}
+	header, rows, numbers, err := readDatasetSample(src, in)
+	if err != nil {
+		if format != unknownDatasetFormat {
+			// Reading errors are reported when loading the dataset.
+			return format, nil
+		}
+		return unknownDatasetFormat, err
+	}
+	detected, reason := detectDatasetFormat(header, rows, numbers)
+	if format != unknownDatasetFormat {
+		if detected != unknownDatasetFormat && detected != format {
+			log.Printf("⚠️  using dataset format %s as requested, but %s looks like format %s: %s", format, datasetName(src), detected, reason)
+		}
+		return format, nil
+	}
+	if detected == unknownDatasetFormat {
+		return unknownDatasetFormat, fmt.Errorf("couldn't detect the format of dataset %s, %s; use -f 1 for airport codes datasets or -f 2 for city names datasets", datasetName(src), reason)
+	}
+	log.Printf("detected dataset format %s: %s", detected, reason)
+	return detected, nil
+}
+
+// readDatasetSample returns the header and up to detectionSampleRows rows of the dataset at src,
+// along with the number of each row.
+func readDatasetSample(src string, in datasetInput) ([]string, [][]string, []int, error) {
+	reader, err := sampleDataset(src, in)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer reader.Close()
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var rows [][]string
+	var numbers []int
+	for len(rows) < detectionSampleRows {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		rows = append(rows, row)
+		numbers = append(numbers, reader.Row())
+	}
+	return header, rows, numbers, nil
+}
+
+// detectDatasetFormat returns the format of a dataset given its header and first rows, numbers being
+// the number of each row, along with the reason it was detected or, if unknown, why it doesn't match
+// any format.
+func detectDatasetFormat(header []string, rows [][]string, numbers []int) (datasetFormat, string) {
+	if len(header) == 0 {
+		return unknownDatasetFormat, "it is empty"
+	}
+	h := make([]string, len(header))
+	for i, c := range header {
+		h[i] = strings.ToLower(strings.TrimSpace(c))
+	}
+	if len(h) >= 6 && airportCodeHeaders[h[0]] && airportCodeHeaders[h[1]] && coordinateHeaders(h[2:6]) {
+		return airportDatasetFormat, fmt.Sprintf("header starts with airport codes and coordinates columns (%s)", strings.Join(header[:6], ","))
+	}
+	if cityHeaders[h[0]] {
+		return citiesDatasetFormat, fmt.Sprintf("header starts with a city name column (%s)", header[0])
+	}
+
+	// Unknown header, tell by the content of the first rows.
+	if len(rows) == 0 {
+		return unknownDatasetFormat, fmt.Sprintf("header %q is not a known one and there are no rows to check", strings.Join(header, ","))
+	}
+	airports, cities := true, true
+	var airportsMismatch, citiesMismatch string
+	for i, row := range rows {
+		line := numbers[i]
+		if airports {
+			if _, err := parseAirportsRow(defaultLayout(airportDatasetFormat), row, line); err != nil {
+				airports = false
+				airportsMismatch = err.Error()
+			}
+		}
+		if cities {
+			city, err := parseCitiesRow(defaultLayout(citiesDatasetFormat), row, line)
+			if err == nil {
+				if _, numErr := strconv.ParseFloat(city, 64); numErr == nil {
+					err = fmt.Errorf("got number %q instead of a city name at row %d", city, line)
+				}
+			}
+			if err != nil {
+				cities = false
+				citiesMismatch = err.Error()
+			}
+		}
+	}
+	switch {
+	case airports:
+		return airportDatasetFormat, fmt.Sprintf("the first %d rows hold airport codes and valid coordinates", len(rows))
+	case cities:
+		return citiesDatasetFormat, fmt.Sprintf("the first %d rows start with a city name", len(rows))
+	}
+	return unknownDatasetFormat, fmt.Sprintf("header %q is not a known one, rows are not airports (%s) nor city names (%s)", strings.Join(header, ","), airportsMismatch, citiesMismatch)
+}
+
+// coordinateHeaders reports whether every column name is a latitude or longitude one.
+func coordinateHeaders(columns []string) bool {
+	for _, c := range columns {
+		known := false
+		for _, prefix := range coordinateHeaderPre {
+			if !strings.HasPrefix(c, prefix) {
+				continue
+			}
+			switch strings.TrimPrefix(c, prefix) {
+			case "lat", "latitude", "latitud", "lon", "lng", "longitude", "longitud":
+				known = true
+			}
+		}
+		if !known {
+			return false
+		}
+	}
+	return true
+}
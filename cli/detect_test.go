@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectDatasetFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		rows       []string
+		want       datasetFormat
+		wantReason string
+	}{
+		{
+			name:       "airports header",
+			header:     "origin,destination,origin_latitude,origin_longitude,destination_latitude,destination_longitude",
+			want:       airportDatasetFormat,
+			wantReason: "header starts with airport codes",
+		},
+		{
+			name:       "cities header",
+			header:     "destino,salida,llegada,fecha de salida",
+			want:       citiesDatasetFormat,
+			wantReason: "header starts with a city name column",
+		},
+		{
+			name:       "airports content",
+			header:     "a,b,c,d,e,f",
+			rows:       []string{"TLC,MTY,19.3371,-99.566,25.7785,-100.107", "MTY,TLC,25.7785,-100.107,19.3371,-99.566"},
+			want:       airportDatasetFormat,
+			wantReason: "the first 2 rows hold airport codes",
+		},
+		{
+			name:       "cities content",
+			header:     "where,when",
+			rows:       []string{"Canada,13:00", "NY,9:00"},
+			want:       citiesDatasetFormat,
+			wantReason: "the first 2 rows start with a city name",
+		},
+		{
+			name:       "unknown content",
+			header:     "id,value",
+			rows:       []string{"1,2"},
+			wantReason: `rows are not airports (missing columns at row 2, got 2) nor city names (got number "1" instead of a city name at row 2)`,
+		},
+		{
+			name:       "unknown header without rows",
+			header:     "id,value",
+			wantReason: "there are no rows to check",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var rows [][]string
+			var numbers []int
+			for i, r := range test.rows {
+				rows = append(rows, strings.Split(r, ","))
+				numbers = append(numbers, i+2)
+			}
+			got, reason := detectDatasetFormat(strings.Split(test.header, ","), rows, numbers)
+			if got != test.want || !strings.Contains(reason, test.wantReason) {
+				t.Errorf("detectDatasetFormat() = %s, %q, want %s and a reason containing %q", got, reason, test.want, test.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// stdinDataset is the dataset location that reads the dataset from the standard input.
+const stdinDataset = "-"
+
+// inputFormat is how dataset records are encoded.
+type inputFormat string
+
+const (
+	// autoInputFormat detects the encoding from the dataset name or first bytes.
+	autoInputFormat inputFormat = ""
+	// csvInputFormat records are lines of comma separated values, or any other delimiter.
+	csvInputFormat inputFormat = "csv"
+	// tsvInputFormat records are lines of tab separated values.
+	tsvInputFormat inputFormat = "tsv"
+	// jsonInputFormat records are the elements of a JSON array or newline delimited JSON values,
+	// either arrays of values whose first one is the header or objects whose keys are the header.
+	jsonInputFormat inputFormat = "json"
+)
+
+// inputSniffSize is the number of bytes checked to detect the encoding of unnamed datasets.
+const inputSniffSize = 4096
+
+// gzipMagic are the first bytes of gzip-compressed content.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// datasetInput tells how dataset records are encoded, zero values are detected.
+type datasetInput struct {
+	format inputFormat
+	// delimiter separates the values of csv and tsv records, zero for the format default.
+	delimiter rune
+}
+
+// registerInputFlags defines dataset encoding flags on fs and returns a function that validates and
+// returns their values once fs is parsed.
+func registerInputFlags(fs *flag.FlagSet) func() (datasetInput, error) {
+	var format, delimiter string
+	fs.StringVar(&format, "input", "", "dataset encoding [csv,tsv,json,ndjson], optionally gzip-compressed (detected from the dataset extension or content by default)")
+	fs.StringVar(&delimiter, "delimiter", "", `values delimiter of csv datasets, e.g: ";" or "\t" (defaults to comma, or tab for tsv)`)
+	return func() (datasetInput, error) {
+		var in datasetInput
+		switch f := inputFormat(strings.ToLower(format)); f {
+		case autoInputFormat, csvInputFormat, tsvInputFormat, jsonInputFormat:
+			in.format = f
+		case "ndjson", "jsonl":
+			in.format = jsonInputFormat
+		default:
+			return in, fmt.Errorf("got invalid input format %q, use csv, tsv, json or ndjson", format)
+		}
+		if delimiter == "" {
+			return in, nil
+		}
+		if delimiter == `\t` || strings.EqualFold(delimiter, "tab") {
+			delimiter = "\t"
+		}
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return in, fmt.Errorf("got invalid delimiter %q, use a single character other than quotes or new lines", delimiter)
+		}
+		if in.format == jsonInputFormat {
+			return in, fmt.Errorf("cannot use a delimiter with json datasets")
+		}
+		in.delimiter = r
+		return in, nil
+	}
+}
+
+// datasetName returns how the dataset at src is referred to in messages.
+func datasetName(src string) string {
+	if src == stdinDataset {
+		return "standard input"
+	}
+	return src
+}
+
+// rowReader reads dataset records one at a time, the header being the first one.
+type rowReader interface {
+	Read() ([]string, error)
+	// Row returns the position of the last record read within the dataset, the one rows are
+	// referred to by: the line it starts at for csv, tsv and newline delimited JSON datasets, or its
+	// position within the array, starting at 1, for JSON arrays.
+	Row() int
+}
+
+// datasetReader reads the records of a dataset, whatever its encoding.
+type datasetReader struct {
+	rowReader
+	close func() error
+}
+
+// Close releases the dataset file, if any.
+func (r *datasetReader) Close() error {
+	return r.close()
+}
+
+// openDataset returns a reader of the records of the dataset at src, or the standard input if src
+// is -, decompressing it if gzip-compressed.
+func openDataset(src string, in datasetInput) (*datasetReader, error) {
+	return openDatasetInput(src, in, false)
+}
+
+// sampleDataset is like openDataset, but whatever is read from the standard input is read again by
+// the next reader, so the dataset can be inspected before loading it.
+func sampleDataset(src string, in datasetInput) (*datasetReader, error) {
+	return openDatasetInput(src, in, true)
+}
+
+func openDatasetInput(src string, in datasetInput, sample bool) (*datasetReader, error) {
+	var r io.Reader
+	closeFn := func() error { return nil }
+	if src == stdinDataset {
+		r = stdin.reader(sample)
+	} else {
+		file, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		r, closeFn = file, file.Close
+	}
+	buffered := bufio.NewReader(r)
+	if magic, _ := buffered.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			closeFn()
+			return nil, fmt.Errorf("failed decompressing %s: %v", datasetName(src), err)
+		}
+		buffered = bufio.NewReader(gz)
+	}
+
+	format := in.format
+	if format == autoInputFormat {
+		format = detectInputFormat(src, buffered)
+	}
+	if format == jsonInputFormat {
+		return &datasetReader{rowReader: newJSONRowReader(buffered), close: closeFn}, nil
+	}
+	lines := &lineReader{r: buffered}
+	reader := csv.NewReader(lines)
+	// Rows with missing columns are reported when parsing them, additional ones are ignored.
+	reader.FieldsPerRecord = -1
+	switch {
+	case in.delimiter != 0:
+		reader.Comma = in.delimiter
+	case format == tsvInputFormat:
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+	return &datasetReader{rowReader: &csvRowReader{Reader: reader, lines: lines}, close: closeFn}, nil
+}
+
+// csvRowReader reads dataset records from lines of delimited values.
+type csvRowReader struct {
+	*csv.Reader
+	// lines counts the lines read by the csv reader.
+	lines *lineReader
+	row   int
+}
+
+// Read returns the next record, io.EOF once there are no more.
+func (r *csvRowReader) Read() ([]string, error) {
+	record, err := r.Reader.Read()
+	if err != nil {
+		return record, err
+	}
+	// The csv reader stops at the last line of the record, quoted values may span several ones.
+	r.row = r.lines.lines
+	for _, v := range record {
+		r.row -= strings.Count(v, "\n")
+	}
+	return record, nil
+}
+
+// Row returns the line the last record read starts at.
+func (r *csvRowReader) Row() int {
+	return r.row
+}
+
+// lineReader reads from r one line at a time at most, so that buffered readers reading from it
+// don't read past the line they need, and counts the lines read.
+type lineReader struct {
+	r *bufio.Reader
+	// rest is what remains of the line being read.
+	rest  []byte
+	lines int
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	if len(l.rest) == 0 {
+		line, err := l.r.ReadSlice('\n')
+		if len(line) == 0 {
+			return 0, err
+		}
+		// Long lines are read in several slices, only the last one ends the line.
+		if err != bufio.ErrBufferFull {
+			l.lines++
+		}
+		l.rest = line
+	}
+	n := copy(p, l.rest)
+	l.rest = l.rest[n:]
+	return n, nil
+}
+
+// detectInputFormat returns the encoding of the dataset at src according to its extension, gz
+// excluded, or, if unknown, to its first bytes.
+func detectInputFormat(src string, r *bufio.Reader) inputFormat {
+	name := strings.TrimSuffix(strings.ToLower(src), ".gz")
+	switch filepath.Ext(name) {
+	case ".csv":
+		return csvInputFormat
+	case ".tsv", ".tab":
+		return tsvInputFormat
+	case ".json", ".ndjson", ".jsonl":
+		return jsonInputFormat
+	}
+	// A full buffer isn't needed to tell, whatever was read is checked.
+	start, _ := r.Peek(inputSniffSize)
+	content := bytes.TrimLeft(start, "\ufeff \t\r\n")
+	if len(content) > 0 && (content[0] == '[' || content[0] == '{') {
+		return jsonInputFormat
+	}
+	header := content
+	if i := bytes.IndexByte(content, '\n'); i >= 0 {
+		header = content[:i]
+	}
+	if bytes.IndexByte(header, '\t') >= 0 && bytes.IndexByte(header, ',') < 0 {
+		return tsvInputFormat
+	}
+	return csvInputFormat
+}
+
+// stdin is the standard input as read by datasets at -.
+var stdin = &replayReader{in: os.Stdin}
+
+// replayReader reads a stream that can only be read once more than once: what samples read is
+// read again by the next reader.
+type replayReader struct {
+	in io.Reader
+	// read is what samples read from in so far.
+	read []byte
+}
+
+// reader returns a reader of the whole stream, recording what it reads from it if sample is set.
+func (s *replayReader) reader(sample bool) io.Reader {
+	replay := bytes.NewReader(s.read)
+	if !sample {
+		// The stream is read through, nothing else needs to be replayed.
+		s.read = nil
+		return io.MultiReader(replay, s.in)
+	}
+	return io.MultiReader(replay, io.TeeReader(s.in, s))
+}
+
+// Write records p as read by a sample.
+func (s *replayReader) Write(p []byte) (int, error) {
+	s.read = append(s.read, p...)
+	return len(p), nil
+}
+
+// jsonRowReader reads dataset records from JSON, see jsonInputFormat. Object records are turned
+// into rows following the keys of the first one, keys it doesn't have are ignored and the ones
+// missing are read as empty values. The header of object records is at the position of the first
+// one.
+type jsonRowReader struct {
+	dec     *json.Decoder
+	lines   *lineCounter
+	started bool
+	// array is whether records are the elements of a top level array.
+	array bool
+	// elements is the number of array elements read.
+	elements int
+	row      int
+	// record is the delimiter of the records read, either [ or {, zero if none was read.
+	record json.Delim
+	// columns are the positions of object keys within rows.
+	columns map[string]int
+	// pending is a row already read that wasn't returned yet.
+	pending []string
+}
+
+func newJSONRowReader(r io.Reader) *jsonRowReader {
+	lines := &lineCounter{r: r}
+	dec := json.NewDecoder(lines)
+	dec.UseNumber()
+	return &jsonRowReader{dec: dec, lines: lines}
+}
+
+// Row returns the line the last record read starts at or, if records are within an array, its
+// position in it.
+func (r *jsonRowReader) Row() int {
+	return r.row
+}
+
+// locate sets the position of the record whose opening token was just read.
+func (r *jsonRowReader) locate() {
+	if r.array {
+		r.elements++
+		r.row = r.elements
+		return
+	}
+	r.row = r.lines.line(r.dec.InputOffset() - 1)
+}
+
+// Read returns the next record, io.EOF once there are no more.
+func (r *jsonRowReader) Read() ([]string, error) {
+	if r.pending != nil {
+		row := r.pending
+		r.pending = nil
+		return row, nil
+	}
+	if !r.started {
+		r.started = true
+		return r.first()
+	}
+	if r.array && !r.dec.More() {
+		return nil, io.EOF
+	}
+	t, err := r.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	r.locate()
+	return r.next(t)
+}
+
+// first reads the first record, telling whether records are within an array or newline delimited.
+func (r *jsonRowReader) first() ([]string, error) {
+	t, err := r.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if t != json.Delim('[') {
+		r.locate()
+		return r.next(t)
+	}
+	start := r.dec.InputOffset() - 1
+	if !r.dec.More() {
+		return nil, io.EOF
+	}
+	if t, err = r.dec.Token(); err != nil {
+		return nil, err
+	}
+	if d, ok := t.(json.Delim); ok && (d == '[' || d == '{') {
+		r.array = true
+		r.locate()
+		return r.next(t)
+	}
+	// Newline delimited arrays, t is the first value of the header.
+	r.record = '['
+	r.row = r.lines.line(start)
+	return r.values(t)
+}
+
+// next returns the record starting with token t.
+func (r *jsonRowReader) next(t json.Token) ([]string, error) {
+	d, ok := t.(json.Delim)
+	if !ok || (d != '[' && d != '{') {
+		return nil, fmt.Errorf("got JSON value %v, want an array or object record", t)
+	}
+	if r.record == 0 {
+		r.record = d
+	}
+	if d != r.record {
+		return nil, fmt.Errorf("got JSON %s record after %s ones, use either arrays or objects", d, r.record)
+	}
+	if d == '{' {
+		return r.object()
+	}
+	t, err := r.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	return r.values(t)
+}
+
+// values returns the values of an array record, starting with token t, until its end.
+func (r *jsonRowReader) values(t json.Token) ([]string, error) {
+	var row []string
+	for t != json.Delim(']') {
+		v, err := jsonValue(t)
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, v)
+		if t, err = r.dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	if row == nil {
+		row = []string{}
+	}
+	return row, nil
+}
+
+// object returns the values of an object record, its keys being the header if it's the first one.
+func (r *jsonRowReader) object() ([]string, error) {
+	var keys, values []string
+	for r.dec.More() {
+		k, err := r.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		t, err := r.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		v, err := jsonValue(t)
+		if err != nil {
+			return nil, fmt.Errorf("%v at key %q", err, k)
+		}
+		keys = append(keys, k.(string))
+		values = append(values, v)
+	}
+	// Closing brace.
+	if _, err := r.dec.Token(); err != nil {
+		return nil, err
+	}
+	if r.columns == nil {
+		r.columns = make(map[string]int, len(keys))
+		for i, k := range keys {
+			r.columns[k] = i
+		}
+		r.pending = values
+		if r.pending == nil {
+			r.pending = []string{}
+		}
+		return keys, nil
+	}
+	row := make([]string, len(r.columns))
+	for i, k := range keys {
+		if c, ok := r.columns[k]; ok {
+			row[c] = values[i]
+		}
+	}
+	return row, nil
+}
+
+// lineCounter numbers the lines of what is read from r, keeping line breaks only until positions
+// past them are located.
+type lineCounter struct {
+	r io.Reader
+	// read is the number of bytes read.
+	read int64
+	// breaks are the offsets of the line breaks read after the last position located.
+	breaks []int64
+	// passed is the number of line breaks before the last position located.
+	passed int
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			c.breaks = append(c.breaks, c.read+int64(i))
+		}
+	}
+	c.read += int64(n)
+	return n, err
+}
+
+// line returns the line, starting at 1, of the byte at offset off, which must not precede the
+// positions already located.
+func (c *lineCounter) line(off int64) int {
+	i := 0
+	for i < len(c.breaks) && c.breaks[i] < off {
+		i++
+	}
+	c.passed += i
+	c.breaks = c.breaks[i:]
+	return c.passed + 1
+}
+
+// jsonValue returns the dataset value of the JSON scalar t.
+func jsonValue(t json.Token) (string, error) {
+	switch v := t.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("got nested JSON %v, want a string, number, boolean or null value", t)
+}
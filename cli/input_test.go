@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// readAllRows returns every record read by r.
+func readAllRows(r rowReader) ([][]string, error) {
+	var rows [][]string
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+func gzipped(t *testing.T, content string) string {
+	t.Helper()
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatalf("gzip.Writer.Write returned unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("gzip.Writer.Close returned unexpected error: %v", err)
+	}
+	return b.String()
+}
+
+func TestOpenDataset(t *testing.T) {
+	want := [][]string{{"city", "departure"}, {"Toluca", "10:00"}, {"Monterrey", "12:30"}}
+	tests := []struct {
+		name    string
+		file    string
+		in      datasetInput
+		content string
+		want    [][]string
+		wantErr string
+	}{
+		{
+			name:    "csv",
+			file:    "dataset.csv",
+			content: "city,departure\nToluca,10:00\nMonterrey,12:30\n",
+			want:    want,
+		},
+		{
+			name:    "tsv by extension",
+			file:    "dataset.tsv",
+			content: "city\tdeparture\nToluca\t10:00\nMonterrey\t12:30\n",
+			want:    want,
+		},
+		{
+			name:    "tsv by content",
+			file:    "dataset",
+			content: "city\tdeparture\nToluca\t10:00\nMonterrey\t12:30\n",
+			want:    want,
+		},
+		{
+			name:    "custom delimiter",
+			file:    "dataset.txt",
+			in:      datasetInput{delimiter: ';'},
+			content: "city;departure\nToluca;10:00\nMonterrey;12:30\n",
+			want:    want,
+		},
+		{
+			name:    "json objects",
+			file:    "dataset.json",
+			content: `[{"city": "Toluca", "departure": "10:00"}, {"departure": "12:30", "city": "Monterrey", "gate": 4}]`,
+			want:    want,
+		},
+		{
+			name:    "json arrays",
+			file:    "dataset",
+			content: `[["city", "departure"], ["Toluca", "10:00"], ["Monterrey", "12:30"]]`,
+			want:    want,
+		},
+		{
+			name:    "ndjson objects",
+			file:    "dataset.ndjson",
+			content: "{\"city\": \"Toluca\", \"departure\": \"10:00\"}\n{\"city\": \"Monterrey\", \"departure\": \"12:30\"}\n",
+			want:    want,
+		},
+		{
+			name:    "ndjson arrays",
+			file:    "dataset",
+			content: "[\"city\", \"departure\"]\n[\"Toluca\", \"10:00\"]\n[\"Monterrey\", \"12:30\"]\n",
+			want:    want,
+		},
+		{
+			name:    "json scalars",
+			file:    "dataset.json",
+			content: `[{"origin_lat": 19.3371, "direct": true, "gate": null}]`,
+			want:    [][]string{{"origin_lat", "direct", "gate"}, {"19.3371", "true", ""}},
+		},
+		{
+			name:    "gzip-compressed",
+			file:    "dataset.json.gz",
+			content: gzipped(t, `[["city", "departure"], ["Toluca", "10:00"], ["Monterrey", "12:30"]]`),
+			want:    want,
+		},
+		{
+			name:    "gzip-compressed without extension",
+			file:    "dataset",
+			content: gzipped(t, "city,departure\nToluca,10:00\nMonterrey,12:30\n"),
+			want:    want,
+		},
+		{
+			name:    "nested json",
+			file:    "dataset.json",
+			content: `[{"city": {"name": "Toluca"}}]`,
+			wantErr: `got nested JSON {, want a string, number, boolean or null value at key "city"`,
+		},
+		{
+			name:    "mixed json records",
+			file:    "dataset.json",
+			content: `[["city"], {"city": "Toluca"}]`,
+			wantErr: "got JSON { record after [ ones",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "input")
+			if err != nil {
+				t.Fatalf("ioutil.TempDir returned unexpected error: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			src := filepath.Join(dir, test.file)
+			if err := ioutil.WriteFile(src, []byte(test.content), 0644); err != nil {
+				t.Fatalf("ioutil.WriteFile returned unexpected error: %v", err)
+			}
+
+			r, err := openDataset(src, test.in)
+			if err != nil {
+				t.Fatalf("openDataset returned unexpected error: %v", err)
+			}
+			defer r.Close()
+			got, err := readAllRows(r)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Read returned error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("got rows %v, want %v\ndiff: got->want %s", got, test.want, diff)
+			}
+		})
+	}
+}
+
+func TestReplayReader(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	s := &replayReader{in: strings.NewReader(content)}
+
+	// Samples only read part of the stream, what they read is read again by the next readers.
+	for _, n := range []int{10, 5000} {
+		sample := make([]byte, n)
+		if _, err := io.ReadFull(s.reader(true), sample); err != nil {
+			t.Fatalf("reading sample returned unexpected error: %v", err)
+		}
+		if got, want := string(sample), content[:n]; got != want {
+			t.Errorf("got sample %q, want %q", got, want)
+		}
+	}
+	got, err := ioutil.ReadAll(s.reader(false))
+	if err != nil {
+		t.Fatalf("reading stream returned unexpected error: %v", err)
+	}
+	if string(got) != content {
+		t.Errorf("got stream of %d bytes, want the whole %d bytes content", len(got), len(content))
+	}
+}
+
+func TestOpenDataset_rows(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []int
+	}{
+		{
+			name:    "csv",
+			file:    "dataset.csv",
+			content: "city,notes\nToluca,\n\n\"Monterrey\",\"two\nlines\"\nPuebla,\n",
+			want:    []int{1, 2, 4, 6},
+		},
+		{
+			name:    "csv without trailing new line",
+			file:    "dataset.csv",
+			content: "city\r\nToluca\r\n\r\nPuebla",
+			want:    []int{1, 2, 4},
+		},
+		{
+			name:    "json objects",
+			file:    "dataset.json",
+			content: "[\n  {\"city\": \"Toluca\"},\n  {\"city\": \"Monterrey\"},\n  {\"city\": \"Puebla\"}\n]\n",
+			want:    []int{1, 1, 2, 3},
+		},
+		{
+			name:    "json arrays",
+			file:    "dataset.json",
+			content: "[\n  [\"city\"],\n  [\"Toluca\"],\n  [\"Monterrey\"]\n]\n",
+			want:    []int{1, 2, 3},
+		},
+		{
+			name:    "ndjson objects",
+			file:    "dataset.ndjson",
+			content: "\n{\"city\": \"Toluca\"}\n\n{\"city\": \"Monterrey\"}\n{\n  \"city\": \"Puebla\"\n}\n",
+			want:    []int{2, 2, 4, 5},
+		},
+		{
+			name:    "ndjson arrays",
+			file:    "dataset.ndjson",
+			content: "[\"city\"]\n\n[\"Toluca\"]\n[\"Monterrey\"]\n",
+			want:    []int{1, 3, 4},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "input")
+			if err != nil {
+				t.Fatalf("ioutil.TempDir returned unexpected error: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			src := filepath.Join(dir, test.file)
+			if err := ioutil.WriteFile(src, []byte(test.content), 0644); err != nil {
+				t.Fatalf("ioutil.WriteFile returned unexpected error: %v", err)
+			}
+
+			r, err := openDataset(src, datasetInput{})
+			if err != nil {
+				t.Fatalf("openDataset returned unexpected error: %v", err)
+			}
+			defer r.Close()
+			var got []int
+			for {
+				_, err := r.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Read returned unexpected error: %v", err)
+				}
+				got = append(got, r.Row())
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("got rows %v, want %v\ndiff: got->want %s", got, test.want, diff)
+			}
+		})
+	}
+}
+
+func TestApp_LoadCitiesDataset_ndjson(t *testing.T) {
+	app := NewApp(&Deps{})
+	app.input = datasetInput{format: jsonInputFormat}
+
+	src := writeDataset(t, "{\"city\": \"Toluca\"}\n\n{\"city\": \"Monterrey\"}\n{\"city\": \"Toluca\"}\n")
+	cities, rows, err := app.LoadCitiesDataset(src)
+	if err != nil {
+		t.Fatalf("LoadCitiesDataset returned unexpected error: %v", err)
+	}
+	if want := []string{"Toluca", "Monterrey", "Toluca"}; !cmp.Equal(cities, want) {
+		t.Errorf("got cities %v, want %v", cities, want)
+	}
+	if want := []int{1, 3, 4}; !cmp.Equal(rows, want) {
+		t.Errorf("got rows %v, want %v", rows, want)
+	}
+
+	src = writeDataset(t, "{\"city\": \"Toluca\"}\n\n{\"city\": \"\"}\n")
+	if _, _, err := app.LoadCitiesDataset(src); err == nil || !strings.Contains(err.Error(), "row 3") {
+		t.Errorf("LoadCitiesDataset returned error %v, want empty city name at row 3", err)
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pablotrinidad/weatherreport/store"
 	"github.com/pablotrinidad/weatherreport/store/openweather"
@@ -19,103 +24,429 @@ const (
 	citiesDatasetFormat
 )
 
+// interruptedExitCode is returned when the program is interrupted by a signal and only a partial
+// report was produced, i.e: 128 + SIGINT as shells do.
+const interruptedExitCode = 130
+
 func init() {
 	log.SetPrefix("")
 	log.SetFlags(0)
 }
 
+// options are the command line flags values.
+type options struct {
+	dataset string
+	format  datasetFormat
+	// columns maps dataset fields to columns, nil for the default layout of the format.
+	columns columnMapping
+	// input tells how the dataset is encoded.
+	input datasetInput
+	lang  string
+	// units in which results are displayed, independent of the units used for fetching them.
+	units  openweather.Units
+	output outputFormat
+	quota  *quotaOptions
+	// dryRun only estimates the work required to fetch the dataset weather.
+	dryRun bool
+	// journal is the location of the checkpoint journal.
+	journal string
+	// resume skips queries completed by previous runs according to the journal.
+	resume bool
+	// grace is how long in-flight API calls are waited for after an interruption.
+	grace time.Duration
+	// concurrency is the maximum number of API calls in flight at the same time.
+	concurrency int
+	// breakerThreshold is the number of consecutive API failures that stop further calls.
+	breakerThreshold int
+	// breakerCooldown is how long calls are stopped before probing the API again.
+	breakerCooldown time.Duration
+	// cacheSize is the maximum number of reports kept in memory, zero disables the cache.
+	cacheSize int
+	// cacheTTL is how long successful reports are cached.
+	cacheTTL time.Duration
+	// cacheNotFoundTTL is how long reports of unknown locations are cached.
+	cacheNotFoundTTL time.Duration
+	// cacheFile is where cached reports are persisted across executions.
+	cacheFile string
+	// offline answers queries solely from the cache, without calling the API.
+	offline bool
+	// verbose text results include the provenance of each report.
+	verbose bool
+	// staleAfter is the age past which observations are flagged as stale, zero disables the check.
+	staleAfter time.Duration
+	// staleRefetch is the number of times stale observations are fetched again.
+	staleRefetch int
+	// staleFallback replaces stale observations with more recent ones cached by previous runs.
+	staleFallback bool
+	// stream fetches the weather while reading the dataset and writes results as they arrive.
+	stream bool
+	// batchSize is the number of distinct queries fetched at once when streaming.
+	batchSize int
+}
+
 func main() {
-	deps, err := getApplicationDependencies()
+	if isSubcommand(os.Args[1:], "quota") {
+		if err := runQuotaCommand(os.Args[2:]); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	if isSubcommand(os.Args[1:], "validate") {
+		valid, err := runValidateCommand(os.Args[2:])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if !valid {
+			os.Exit(invalidDatasetExitCode)
+		}
+		return
+	}
+
+	opts, err := read()
 	if err != nil {
+		log.Fatalf("%v\nuse -h flag for usage instructions", err)
+	}
+	if opts.format, err = resolveDatasetFormat(opts.dataset, opts.input, opts.format, opts.columns); err != nil {
 		log.Fatalf("%v", err)
 	}
-	app := NewApp(deps)
 
-	dataset, format, err := read()
+	config, err := getConfig(opts)
 	if err != nil {
-		log.Fatalf("%v\nuse -h flag for usage instructions", err)
+		log.Fatalf("failed obtaining configuration: %v", err)
 	}
+	// From here on, everything written to the console goes through a redaction layer so API keys
+	// never show up in logs, errors or reports.
+	log.SetOutput(newRedactingWriter(os.Stderr, config.secrets()))
+	stdout := newRedactingWriter(os.Stdout, config.secrets())
 
-	var report map[string]store.WeatherReport
-	switch format {
+	deps, err := getApplicationDependencies(config, opts)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	app := NewApp(deps)
+	app.columns = opts.columns
+	app.input = opts.input
+	ctx := handleSignals()
+
+	if opts.stream {
+		streamResults(ctx, app, deps, opts, stdout)
+		return
+	}
+
+	var report *store.ResultSet
+	switch opts.format {
 	case airportDatasetFormat:
-		airports, err := app.LoadAirportsDataset(dataset)
+		airports, rows, err := app.LoadAirportsDataset(opts.dataset)
 		if err != nil {
 			log.Fatalf("Failed loading dataset:\n\t%v", err)
 		}
-		report, err = app.GetAirportsWeather(airports)
+		if opts.dryRun {
+			if err := app.PlanAirportsWeather(airports); err != nil {
+				log.Fatalf("Failed planning weather report:\n\t%v", err)
+			}
+			return
+		}
+		report, err = app.GetAirportsWeather(ctx, airports, rows)
 		if err != nil {
 			log.Fatalf("Failed obtaining weather report:\n\t%v", err)
 		}
 	case citiesDatasetFormat:
-		cities, err := app.LoadCitiesDataset(dataset)
+		cities, rows, err := app.LoadCitiesDataset(opts.dataset)
 		if err != nil {
 			log.Fatalf("Failed loading dataset:\n\t%v\n", err)
 		}
-		report, err = app.GetCitiesWeather(cities)
+		if opts.dryRun {
+			if err := app.PlanCitiesWeather(cities); err != nil {
+				log.Fatalf("Failed planning weather report:\n\t%v", err)
+			}
+			return
+		}
+		report, err = app.GetCitiesWeather(ctx, cities, rows)
 		if err != nil {
 			log.Fatalf("Failed obtaining weather report:\n\t%v", err)
 		}
 	}
 
-	printResults(report)
+	if err := deps.saveCache(); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	if err := deps.closeQuota(); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	interrupted := ctx.Err() != nil
+	switch {
+	case opts.output == jsonOutputFormat:
+		if err := writeJSONResults(stdout, report, opts.units); err != nil {
+			log.Fatalf("Failed writing results:\n\t%v", err)
+		}
+	case interrupted, opts.dataset == stdinDataset:
+		// Nobody may be around to confirm partial results, and the standard input holds the
+		// dataset rather than answers, so results are printed right away.
+		writeTextResults(stdout, report, opts.units, opts.verbose)
+	default:
+		printResults(stdout, report, opts.units, opts.verbose)
+	}
+	if interrupted {
+		printNotAttempted(notAttempted(report))
+		os.Exit(interruptedExitCode)
+	}
+}
+
+// streamResults fetches the weather of the dataset while reading it and writes results to w as
+// they arrive, see App.StreamWeather.
+func streamResults(ctx context.Context, app *App, deps *Deps, opts *options, w io.Writer) {
+	var out resultWriter = &textResultWriter{w: w, units: opts.units, verbose: opts.verbose}
+	if opts.output == jsonOutputFormat {
+		out = newJSONResultWriter(w, opts.units)
+	}
+	notAttempted, err := app.StreamWeather(ctx, opts.dataset, opts.format, opts.batchSize, out)
+	if err := deps.saveCache(); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	if err := deps.closeQuota(); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	if err != nil {
+		log.Fatalf("Failed obtaining weather report:\n\t%v", err)
+	}
+	if ctx.Err() != nil {
+		printNotAttempted(notAttempted)
+		log.Printf("rows read after the interruption were not queried")
+		os.Exit(interruptedExitCode)
+	}
+}
+
+// handleSignals returns a context cancelled upon receiving SIGINT or SIGTERM, which stops the store
+// from performing new API calls. A second signal terminates the program right away.
+func handleSignals() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("\n🛑 received %v, waiting for in-flight API calls before printing partial results (send again to quit now)", sig)
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx
 }
 
 // getApplicationDependencies returns newly initialized application dependencies.
-func getApplicationDependencies() (*Deps, error) {
-	config, err := getConfig()
+func getApplicationDependencies(config *Config, opts *options) (*Deps, error) {
+	if opts.offline {
+		cache, err := store.NewOfflineStore(opts.cacheSize, store.WithCacheLanguage(config.language), store.WithCacheStaleAfter(opts.staleAfter))
+		if err != nil {
+			return nil, fmt.Errorf("failed initializing cache: %v", err)
+		}
+		if err := cache.Load(opts.cacheFile); err != nil {
+			return nil, err
+		}
+		log.Printf("offline mode, answering from %d reports cached at %s", cache.Stats().Entries, opts.cacheFile)
+		return &Deps{store: cache}, nil
+	}
+
+	quotaOpts := opts.quota
+	quota, err := store.OpenQuota(quotaOpts.file, quotaOpts.budget)
 	if err != nil {
-		return nil, fmt.Errorf("failed obtaining configuration: %v", err)
+		return nil, err
 	}
-	ow, err := openweather.NewAPIClient(config.openweatherAPIKey, "metric")
+	perMinute := int(quotaOpts.budget.PerMinute)
+	if perMinute == 0 {
+		perMinute = int(store.Plans["free"].PerMinute)
+	}
+
+	// All clients share the same connection pool, sized to the store's concurrency.
+	owOpts := []openweather.Option{
+		openweather.WithTransport(openweather.NewTransport(opts.concurrency)),
+	}
+	if config.language != "" {
+		owOpts = append(owOpts, openweather.WithLanguage(config.language))
+	}
+	clients := make([]store.KeyedAPI, len(config.openweatherAPIKeys))
+	for i, key := range config.openweatherAPIKeys {
+		// Results are always fetched in metric units, conversions happen when displaying them.
+		ow, err := openweather.NewAPIClient(key, string(openweather.Metric), owOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed initializing OpenWeather API Client: %v", err)
+		}
+		clients[i] = quota.Wrap(ow)
+	}
+	pool, err := store.NewKeyPool(perMinute, clients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed initializing API keys pool: %v", err)
+	}
+	breaker, err := store.NewCircuitBreaker(pool, opts.breakerThreshold, opts.breakerCooldown, func(t store.CircuitTransition) {
+		if t.To == store.CircuitClosed {
+			log.Printf("\t\t\t⚡ circuit breaker %s -> %s, API calls resumed", t.From, t.To)
+			return
+		}
+		log.Printf("\t\t\t⚡ circuit breaker %s -> %s: %v", t.From, t.To, t.Cause)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed initializing circuit breaker: %v", err)
+	}
+	storeOpts := []store.Option{
+		store.WithRequestsPerMinute(pool.RequestsPerMinute()),
+		store.WithConcurrency(opts.concurrency),
+	}
+	// Dry runs must leave the journal untouched, it is only read when resuming.
+	if !opts.dryRun || opts.resume {
+		// Checkpoints are a convenience, e.g: datasets in read-only directories can still be fetched,
+		// unless resuming was asked for.
+		journal, err := store.OpenJournal(opts.journal, opts.resume)
+		switch {
+		case err != nil && opts.resume:
+			return nil, err
+		case err != nil:
+			log.Printf("⚠️  %v, progress won't be recorded", err)
+		default:
+			storeOpts = append(storeOpts, store.WithJournal(journal))
+			if journal.Rotated() != "" {
+				log.Printf("progress of the previous run kept at %s, use -journal %s -resume to continue it", journal.Rotated(), journal.Rotated())
+			}
+			log.Printf("recording progress at %s, use -resume to continue an interrupted run", journal.Path())
+		}
+	}
+	storeOpts = append(storeOpts, store.WithGracePeriod(opts.grace), store.WithStaleAfter(opts.staleAfter), store.WithStaleRefetch(opts.staleRefetch))
+	s := store.NewConcurrentStore(breaker, storeOpts...)
+	if opts.cacheSize <= 0 {
+		return &Deps{store: s, quota: quota}, nil
+	}
+	cache, err := store.NewCachedStore(s, opts.cacheSize, opts.cacheTTL, opts.cacheNotFoundTTL, store.WithCacheLanguage(config.language), store.WithCacheStaleAfter(opts.staleAfter))
+	if err != nil {
+		return nil, fmt.Errorf("failed initializing cache: %v", err)
+	}
+	if err := cache.Load(opts.cacheFile); err != nil {
+		return nil, err
+	}
+	if !opts.staleFallback {
+		return &Deps{store: cache, cache: cache, cacheFile: opts.cacheFile, quota: quota}, nil
+	}
+	// Reports cached by previous runs are kept regardless of their TTL and may hold more recent
+	// observations than the ones returned by the API right now.
+	previous, err := store.NewOfflineStore(opts.cacheSize, store.WithCacheLanguage(config.language), store.WithCacheStaleAfter(opts.staleAfter))
+	if err != nil {
+		return nil, fmt.Errorf("failed initializing cache: %v", err)
+	}
+	if err := previous.Load(opts.cacheFile); err != nil {
+		return nil, err
+	}
+	fallback, err := store.NewStaleFallback(cache, previous, opts.staleAfter)
 	if err != nil {
-		return nil, fmt.Errorf("failed initializing OpenWeather API Client: %v", err)
+		return nil, fmt.Errorf("failed initializing stale observations fallback: %v", err)
 	}
-	return &Deps{store: store.NewConcurrentStore(ow)}, nil
+	return &Deps{store: fallback, cache: cache, cacheFile: opts.cacheFile, quota: quota}, nil
 }
 
 // read command line flags.
-func read() (string, datasetFormat, error) {
-	var dataset string
+func read() (*options, error) {
+	var dataset, lang, units, output, journal, cacheFile string
 	var format uint
-	flag.StringVar(&dataset, "d", "", "path to dataset location")
-	flag.UintVar(&format, "f", 0, "dataset format [1,2]:\n\t1: Airport codes dataset\n\t2: City names dataset")
+	var dryRun, resume, offline, verbose, staleFallback, stream bool
+	var grace time.Duration
+	var concurrency, breakerThreshold, cacheSize, staleRefetch, batchSize int
+	var breakerCooldown, cacheTTL, cacheNotFoundTTL, staleAfter time.Duration
+	flag.StringVar(&dataset, "d", "", "path to dataset location, - to read it from the standard input")
+	flag.UintVar(&format, "f", 0, datasetFormatUsage)
+	flag.StringVar(&lang, "lang", "", "language of weather descriptions, e.g: es for Spanish (defaults to English)")
+	flag.StringVar(&units, "units", string(openweather.Metric), "units used to display results [standard,metric,imperial]")
+	flag.StringVar(&output, "o", string(textOutputFormat), "output format [text,json]")
+	flag.BoolVar(&dryRun, "dry-run", false, "validate the dataset and estimate API calls and time without performing any call")
+	flag.StringVar(&journal, "journal", "", "checkpoint journal location (defaults to DATASET.journal)")
+	flag.BoolVar(&resume, "resume", false, "skip queries completed by a previous run according to the journal and merge their results")
+	flag.DurationVar(&grace, "grace", 10*time.Second, "how long to wait for in-flight API calls when interrupted")
+	flag.IntVar(&concurrency, "concurrency", store.DefaultConcurrency, "maximum number of API calls in flight at the same time, independent of the per minute rate limit")
+	flag.IntVar(&breakerThreshold, "breaker-threshold", 5, "consecutive server, rate-limited or network errors that stop further API calls")
+	flag.DurationVar(&breakerCooldown, "breaker-cooldown", 30*time.Second, "how long API calls are stopped before trying again after too many errors")
+	flag.IntVar(&cacheSize, "cache-size", 10000, "maximum number of reports kept in memory (0 disables the cache)")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 10*time.Minute, "how long reports are cached")
+	flag.DurationVar(&cacheNotFoundTTL, "cache-not-found-ttl", time.Minute, "how long reports of locations unknown to OpenWeather are cached")
+	flag.StringVar(&cacheFile, "cache-file", userCacheFile("cache.json"), "file where cached reports are kept across executions")
+	flag.BoolVar(&offline, "offline", false, "answer solely from cached reports, regardless of their age, without calling the API")
+	flag.DurationVar(&staleAfter, "stale-after", time.Hour, "age past which observations are flagged as stale (0 disables the check)")
+	flag.IntVar(&staleRefetch, "stale-refetch", 0, "number of times stale observations are fetched again, keeping the most recent one")
+	flag.BoolVar(&staleFallback, "stale-fallback", false, "replace stale observations with more recent ones cached by previous runs")
+	flag.BoolVar(&stream, "stream", false, "fetch the weather while reading the dataset and write each result as soon as it arrives, for datasets too large to load at once")
+	flag.IntVar(&batchSize, "batch-size", defaultBatchSize, "number of distinct queries fetched at once with -stream")
+	flag.BoolVar(&verbose, "v", false, "include where each report came from in text results (provider, endpoint, latency, cache...)")
+	columns := registerColumnsFlags(flag.CommandLine)
+	input := registerInputFlags(flag.CommandLine)
+	quotaOpts := registerQuotaFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n\t%s -d DATASET [-f FORMAT | -columns MAPPING] [flags]\n\t%s quota [-d DATASET [-f FORMAT | -columns MAPPING]] [flags]\n\t%s validate -d DATASET [-f FORMAT | -columns MAPPING] [-o FORMAT]\n\nFlags:\n", os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 	if dataset == "" {
-		return "", unknownDatasetFormat, fmt.Errorf("cannot use empty dataset location")
+		return nil, fmt.Errorf("cannot use empty dataset location")
+	}
+	if format > 2 {
+		return nil, fmt.Errorf("got invalid dataset format %d, use 1 for airport codes dataset and 2 for city names dataset, or leave it out to detect it", format)
+	}
+	mapping, err := columns()
+	if err != nil {
+		return nil, err
+	}
+	in, err := input()
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := openweather.ParseUnits(units)
+	if err != nil {
+		return nil, err
+	}
+	switch outputFormat(output) {
+	case textOutputFormat, jsonOutputFormat:
+	default:
+		return nil, fmt.Errorf("got invalid output format %q, use text or json", output)
+	}
+	if concurrency <= 0 {
+		return nil, fmt.Errorf("got invalid concurrency %d, it must be a positive number", concurrency)
 	}
-	if format <= 0 || format > 2 {
-		return "", unknownDatasetFormat, fmt.Errorf("got invalid dataset format %d, use 1 for airport codes dataset and 2 for city names dataset", format)
+	if offline && cacheSize <= 0 {
+		return nil, fmt.Errorf("offline mode requires the cache, use a positive -cache-size")
+	}
+	if stream && dryRun {
+		return nil, fmt.Errorf("-dry-run requires the whole dataset and cannot be used with -stream")
+	}
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("got invalid batch size %d, it must be a positive number", batchSize)
+	}
+	if staleAfter < 0 || staleRefetch < 0 {
+		return nil, fmt.Errorf("got invalid stale observations settings, -stale-after and -stale-refetch cannot be negative")
+	}
+	if (staleRefetch > 0 || staleFallback) && staleAfter == 0 {
+		return nil, fmt.Errorf("-stale-refetch and -stale-fallback require a positive -stale-after")
+	}
+	if staleFallback && cacheSize <= 0 {
+		return nil, fmt.Errorf("-stale-fallback requires the cache, use a positive -cache-size")
+	}
+	if breakerThreshold <= 0 || breakerCooldown <= 0 {
+		return nil, fmt.Errorf("got invalid circuit breaker settings, threshold and cooldown must be positive")
+	}
+	q, err := quotaOpts()
+	if err != nil {
+		return nil, err
+	}
+	if journal == "" {
+		journal = dataset + ".journal"
+		if dataset == stdinDataset {
+			journal = userCacheFile("stdin.journal")
+		}
 	}
 
-	return dataset, datasetFormat(format), nil
+	return &options{dataset: dataset, format: datasetFormat(format), columns: mapping, input: in, lang: lang, units: u, output: outputFormat(output), quota: q, dryRun: dryRun, journal: journal, resume: resume, grace: grace, concurrency: concurrency, breakerThreshold: breakerThreshold, breakerCooldown: breakerCooldown, cacheSize: cacheSize, cacheTTL: cacheTTL, cacheNotFoundTTL: cacheNotFoundTTL, cacheFile: cacheFile, offline: offline, verbose: verbose, staleAfter: staleAfter, staleRefetch: staleRefetch, staleFallback: staleFallback, stream: stream, batchSize: batchSize}, nil
 }
 
-// printResults upon confirmation.
-func printResults(results map[string]store.WeatherReport) {
-	fmt.Printf("\nDo you want to print %d results? [y/N]: ", len(results))
+// printResults to w upon confirmation, expressed in the given units.
+func printResults(w io.Writer, results *store.ResultSet, units openweather.Units, verbose bool) {
+	fmt.Printf("\nDo you want to print %d results? [y/N]: ", len(results.Unique()))
 	if !confirmation() {
 		fmt.Println("\nBYE 👋!")
 		os.Exit(0)
 	}
-	for k, r := range results {
-		fmt.Println("==========================================")
-		fmt.Printf("q: %s\n", k)
-		if r.Failed {
-			fmt.Printf("\tcouldn't get weather information for %q\n", k)
-			fmt.Printf("\treason: %s\n", r.FailMessage)
-			continue
-		}
-		fmt.Printf("\tcity name: %s\n", r.CityName)
-		fmt.Printf("\tlat:%0.2f lon: %0.2f\n", r.Lat, r.Lon)
-		fmt.Printf("\tdescription: %v\n", r.Description)
-		fmt.Printf("\ttemp: %0.2f°C\n", r.Temp)
-		fmt.Printf("\t\tmax: %0.2f°C\n", r.MaxTemp)
-		fmt.Printf("\t\tmin: %0.2f°C\n", r.MinTemp)
-		fmt.Printf("\t\tfeels like: %0.2f°C\n", r.FeelsLike)
-		fmt.Printf("\thumidity: %d%%\n", r.Humidity)
-		fmt.Printf("\tobservation time: %v\n", r.ObservationTime)
-	}
+	writeTextResults(w, results, units, verbose)
 }
 
 func confirmation() bool {
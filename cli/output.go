@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pablotrinidad/weatherreport/store"
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+type outputFormat string
+
+const (
+	textOutputFormat outputFormat = "text"
+	jsonOutputFormat outputFormat = "json"
+)
+
+// writeTextResults writes human readable results to w in dataset order, expressed in the given
+// units. Verbose results include where each report came from.
+func writeTextResults(w io.Writer, results *store.ResultSet, units openweather.Units, verbose bool) {
+	for _, res := range results.Unique() {
+		writeTextResult(w, res.Key, results.Rows(res.Key), res.Report, units, verbose)
+	}
+}
+
+// writeTextResult writes the human readable report r of the query with the given key and dataset
+// rows to w.
+func writeTextResult(w io.Writer, k string, rows []int, r store.WeatherReport, units openweather.Units, verbose bool) {
+	fmt.Fprintln(w, "==========================================")
+	fmt.Fprintf(w, "q: %s\n", k)
+	fmt.Fprintf(w, "\trows: %s\n", joinRows(rows))
+	if r.Failed {
+		fmt.Fprintf(w, "\tcouldn't get weather information for %q\n", k)
+		fmt.Fprintf(w, "\treason: %s\n", r.FailMessage)
+		if verbose {
+			writeProvenance(w, r.Provenance)
+		}
+		return
+	}
+	r = r.ConvertTo(units)
+	temp := r.Units.TemperatureSymbol()
+	fmt.Fprintf(w, "\tcity name: %s\n", r.CityName)
+	fmt.Fprintf(w, "\tlat:%0.2f lon: %0.2f\n", r.Lat, r.Lon)
+	if len(r.Details) > 0 {
+		fmt.Fprintf(w, "\tdescription: %s\n", strings.Join(r.Details, ", "))
+	} else {
+		fmt.Fprintf(w, "\tdescription: %v\n", r.Description)
+	}
+	fmt.Fprintf(w, "\ttemp: %0.2f%s\n", r.Temp, temp)
+	fmt.Fprintf(w, "\t\tmax: %0.2f%s\n", r.MaxTemp, temp)
+	fmt.Fprintf(w, "\t\tmin: %0.2f%s\n", r.MinTemp, temp)
+	fmt.Fprintf(w, "\t\tfeels like: %0.2f%s\n", r.FeelsLike, temp)
+	fmt.Fprintf(w, "\thumidity: %d%%\n", r.Humidity)
+	fmt.Fprintf(w, "\twind speed: %0.2f %s\n", r.WindSpeed, r.Units.SpeedSymbol())
+	fmt.Fprintf(w, "\tobservation time: %v\n", r.ObservationTime)
+	if r.Stale {
+		fmt.Fprintf(w, "\tstale: observation may not reflect current conditions\n")
+	}
+	if r.Age > 0 {
+		fmt.Fprintf(w, "\tcached: fetched %s ago\n", r.Age.Round(time.Second))
+	}
+	if verbose {
+		writeProvenance(w, r.Provenance)
+	}
+}
+
+// writeProvenance writes the known provenance details of a report to w.
+func writeProvenance(w io.Writer, p store.Provenance) {
+	if p.Provider != "" {
+		fmt.Fprintf(w, "\tprovider: %s\n", p.Provider)
+	}
+	if p.Endpoint != "" {
+		fmt.Fprintf(w, "\tendpoint: %s\n", p.Endpoint)
+	}
+	if !p.FetchedAt.IsZero() {
+		fmt.Fprintf(w, "\tfetched at: %v\n", p.FetchedAt)
+		fmt.Fprintf(w, "\tlatency: %s (%d attempts)\n", p.Latency, p.Attempts)
+	}
+	if p.Cache != "" {
+		fmt.Fprintf(w, "\tcache: %s\n", p.Cache)
+	}
+	if p.SharedFrom != "" {
+		fmt.Fprintf(w, "\tshared from: %s\n", p.SharedFrom)
+	}
+}
+
+// joinRows returns the comma separated list of the given dataset rows.
+func joinRows(rows []int) string {
+	s := make([]string, len(rows))
+	for i, r := range rows {
+		s[i] = strconv.Itoa(r)
+	}
+	return strings.Join(s, ", ")
+}
+
+// jsonResult is a single query result as exported in JSON output.
+type jsonResult struct {
+	Query string `json:"query"`
+	// Rows are the dataset rows of every occurrence of the query.
+	Rows   []int               `json:"rows"`
+	Report store.WeatherReport `json:"report"`
+}
+
+// writeJSONResults writes results to w as a JSON array in dataset order, expressed in the given
+// units.
+func writeJSONResults(w io.Writer, results *store.ResultSet, units openweather.Units) error {
+	out := newJSONResultWriter(w, units)
+	for _, r := range results.Unique() {
+		if err := out.write(r.Key, results.Rows(r.Key), r.Report); err != nil {
+			return err
+		}
+	}
+	return out.close()
+}
+
+// resultWriter writes query results one at a time, as they become available.
+type resultWriter interface {
+	// write the report r of the query with the given key and dataset rows.
+	write(key string, rows []int, r store.WeatherReport) error
+	// close flushes any pending output, no results can be written afterwards.
+	close() error
+}
+
+// textResultWriter writes human readable results, see writeTextResult.
+type textResultWriter struct {
+	w       io.Writer
+	units   openweather.Units
+	verbose bool
+}
+
+func (t *textResultWriter) write(key string, rows []int, r store.WeatherReport) error {
+	writeTextResult(t.w, key, rows, r, t.units, t.verbose)
+	return nil
+}
+
+func (t *textResultWriter) close() error {
+	return nil
+}
+
+// jsonResultWriter writes results as the elements of a JSON array, opened with the first result.
+type jsonResultWriter struct {
+	w       io.Writer
+	units   openweather.Units
+	written int
+}
+
+func newJSONResultWriter(w io.Writer, units openweather.Units) *jsonResultWriter {
+	return &jsonResultWriter{w: w, units: units}
+}
+
+func (j *jsonResultWriter) write(key string, rows []int, r store.WeatherReport) error {
+	content, err := json.MarshalIndent(jsonResult{Query: key, Rows: rows, Report: r.ConvertTo(j.units)}, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if j.written == 0 {
+		sep = "[\n  "
+	}
+	j.written++
+	_, err = fmt.Fprintf(j.w, "%s%s", sep, content)
+	return err
+}
+
+func (j *jsonResultWriter) close() error {
+	if j.written == 0 {
+		_, err := fmt.Fprintln(j.w, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(j.w, "\n]")
+	return err
+}
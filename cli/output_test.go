@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pablotrinidad/weatherreport/store"
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+func TestWriteJSONResults_DatasetOrder(t *testing.T) {
+	queries := []store.Query{
+		{Key: "Toluca", Row: 2},
+		{Key: "Acapulco", Row: 3},
+		{Key: "Toluca", Row: 4},
+		{Key: "Monterrey", Row: 5},
+	}
+	reports := map[string]store.WeatherReport{
+		"Toluca":    {CityName: "Toluca"},
+		"Acapulco":  {CityName: "Acapulco"},
+		"Monterrey": {CityName: "Monterrey"},
+	}
+	// Maps are iterated in random order, repeated runs must produce the same output.
+	for i := 0; i < 10; i++ {
+		var out bytes.Buffer
+		if err := writeJSONResults(&out, store.NewResultSet(queries, reports), openweather.Metric); err != nil {
+			t.Fatalf("writeJSONResults returned unexpected error: %v", err)
+		}
+		var got []struct {
+			Query string `json:"query"`
+			Rows  []int  `json:"rows"`
+		}
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+			t.Fatalf("writeJSONResults wrote invalid JSON: %v", err)
+		}
+		want := []struct {
+			Query string `json:"query"`
+			Rows  []int  `json:"rows"`
+		}{
+			{Query: "Toluca", Rows: []int{2, 4}},
+			{Query: "Acapulco", Rows: []int{3}},
+			{Query: "Monterrey", Rows: []int{5}},
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Fatalf("got %v, want %v\ndiff: got->want %s", got, want, diff)
+		}
+	}
+}
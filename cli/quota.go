@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pablotrinidad/weatherreport/store"
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+// quotaOptions are the flags values that configure API keys budget accounting.
+type quotaOptions struct {
+	plan   string
+	budget store.Budget
+	// file where calls counters are persisted.
+	file string
+}
+
+// registerQuotaFlags defines quota flags on fs and returns a function that validates and returns
+// their values once fs is parsed.
+func registerQuotaFlags(fs *flag.FlagSet) func() (*quotaOptions, error) {
+	var plan, file string
+	var perDay, perMonth uint
+	plans := make([]string, 0, len(store.Plans))
+	for p := range store.Plans {
+		plans = append(plans, p)
+	}
+	sort.Strings(plans)
+	fs.StringVar(&plan, "plan", "free", fmt.Sprintf("OpenWeather subscription plan of the API keys %v", plans))
+	fs.UintVar(&perDay, "daily-budget", 0, "maximum number of calls per key and day (defaults to the plan limit)")
+	fs.UintVar(&perMonth, "monthly-budget", 0, "maximum number of calls per key and month (defaults to the plan limit)")
+	fs.StringVar(&file, "quota-file", userCacheFile("quota.json"), "file where API calls are accounted for across executions")
+	return func() (*quotaOptions, error) {
+		budget, ok := store.Plans[plan]
+		if !ok {
+			return nil, fmt.Errorf("got unknown plan %q, use one of %v", plan, plans)
+		}
+		if perDay > 0 {
+			budget.PerDay = perDay
+		}
+		if perMonth > 0 {
+			budget.PerMonth = perMonth
+		}
+		return &quotaOptions{plan: plan, budget: budget, file: file}, nil
+	}
+}
+
+// userCacheFile returns the location of the named file within the program's user cache directory.
+func userCacheFile(name string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "weatherreport", name)
+}
+
+// runQuotaCommand shows the remaining budget of each configured API key and, if a dataset is
+// given, projects whether fetching its weather fits in it.
+func runQuotaCommand(args []string) error {
+	fs := flag.NewFlagSet("quota", flag.ExitOnError)
+	var dataset string
+	var format uint
+	fs.StringVar(&dataset, "d", "", "path to dataset location, - to read it from the standard input (optional)")
+	fs.UintVar(&format, "f", 0, datasetFormatUsage)
+	columnsOpt := registerColumnsFlags(fs)
+	inputOpt := registerInputFlags(fs)
+	quotaOpts := registerQuotaFlags(fs)
+	fs.Parse(args)
+	qOpts, err := quotaOpts()
+	if err != nil {
+		return err
+	}
+	columns, err := columnsOpt()
+	if err != nil {
+		return err
+	}
+	in, err := inputOpt()
+	if err != nil {
+		return err
+	}
+
+	config, err := getConfig(&options{})
+	if err != nil {
+		return fmt.Errorf("failed obtaining configuration: %v", err)
+	}
+	log.SetOutput(newRedactingWriter(os.Stderr, config.secrets()))
+	quota, err := store.OpenQuota(qOpts.file, qOpts.budget)
+	if err != nil {
+		return err
+	}
+
+	b := quota.Budget()
+	log.Printf("plan %s: %s per minute, %s per day and %s per month for each key", qOpts.plan, limit(b.PerMinute), limit(b.PerDay), limit(b.PerMonth))
+	var totalRemaining uint
+	limited := false
+	for _, key := range config.openweatherAPIKeys {
+		ow, err := openweather.NewAPIClient(key, string(openweather.Metric))
+		if err != nil {
+			return err
+		}
+		u := quota.Usage(ow.KeyID())
+		remaining, keyLimited := u.Remaining(b)
+		log.Printf("\tkey %s: %d calls this minute, %d today, %d this month, remaining today: %s", u.KeyID, u.MinuteCalls, u.DayCalls, u.MonthCalls, remainingString(remaining, keyLimited))
+		if keyLimited {
+			totalRemaining += remaining
+			limited = true
+		}
+	}
+
+	if dataset == "" {
+		return nil
+	}
+	f, err := resolveDatasetFormat(dataset, in, datasetFormat(format), columns)
+	if err != nil {
+		return err
+	}
+	queries, err := countUniqueQueries(dataset, in, f, columns)
+	if err != nil {
+		return err
+	}
+	perMinute := int(b.PerMinute) * len(config.openweatherAPIKeys)
+	minutes := 0
+	if perMinute > 0 {
+		minutes = (queries + perMinute - 1) / perMinute
+	}
+	log.Printf("dataset %s requires %d API calls (~%d minutes)", datasetName(dataset), queries, minutes)
+	if limited && uint(queries) > totalRemaining {
+		log.Printf("\t❌  does not fit in the remaining budget of %d calls", totalRemaining)
+	} else {
+		log.Printf("\t✅  fits in the remaining budget of %s calls", remainingString(totalRemaining, limited))
+	}
+	return nil
+}
+
+// countUniqueQueries returns the number of distinct API queries needed to fetch the dataset weather.
+func countUniqueQueries(dataset string, in datasetInput, format datasetFormat, columns columnMapping) (int, error) {
+	app := NewApp(&Deps{})
+	app.columns = columns
+	app.input = in
+	unique := make(map[string]bool)
+	switch format {
+	case airportDatasetFormat:
+		airports, _, err := app.LoadAirportsDataset(dataset)
+		if err != nil {
+			return 0, err
+		}
+		for _, a := range airports {
+			unique[a.Code] = true
+		}
+	case citiesDatasetFormat:
+		cities, _, err := app.LoadCitiesDataset(dataset)
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range cities {
+			unique[c] = true
+		}
+	default:
+		return 0, fmt.Errorf("got invalid dataset format %d, use 1 for airport codes dataset and 2 for city names dataset", format)
+	}
+	return len(unique), nil
+}
+
+func limit(n uint) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(n)
+}
+
+func remainingString(n uint, limited bool) string {
+	if !limited {
+		return "unlimited"
+	}
+	return fmt.Sprint(n)
+}
+
+// isSubcommand returns whether args start with the given subcommand name.
+func isSubcommand(args []string, name string) bool {
+	return len(args) > 0 && strings.EqualFold(args[0], name)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+// redactingWriter is an io.Writer that replaces secrets with openweather.Redacted before writing
+// to the underlying writer. Secrets are matched within each Write call, callers must not split a
+// secret across multiple writes (e.g: log and fmt.Fprint* write whole messages at once).
+type redactingWriter struct {
+	w        io.Writer
+	replacer *strings.Replacer
+}
+
+// newRedactingWriter returns a writer that scrubs the given secrets, in both their raw and
+// URL-encoded forms, from everything written to w.
+func newRedactingWriter(w io.Writer, secrets []string) io.Writer {
+	var pairs []string
+	for _, s := range secrets {
+		if s == "" {
+			continue
+		}
+		pairs = append(pairs, s, openweather.Redacted)
+		if escaped := url.QueryEscape(s); escaped != s {
+			pairs = append(pairs, escaped, openweather.Redacted)
+		}
+	}
+	if len(pairs) == 0 {
+		return w
+	}
+	return &redactingWriter{w: w, replacer: strings.NewReplacer(pairs...)}
+}
+
+// Write writes the redacted version of p to the underlying writer. It reports len(p) bytes
+// written on success since redaction can change the output length.
+func (r *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, r.replacer.Replace(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pablotrinidad/weatherreport/store"
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+const secretAPIKey = "s3cr3t+k3y/42="
+
+// assertNoSecret fails the test if out contains the secret API key in either raw or URL-encoded form.
+func assertNoSecret(t *testing.T, outputPath, out string) {
+	t.Helper()
+	if strings.Contains(out, secretAPIKey) || strings.Contains(out, url.QueryEscape(secretAPIKey)) {
+		t.Errorf("%s exposes the API key:\n%s", outputPath, out)
+	}
+}
+
+func TestRedactingWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets []string
+		in      string
+		want    string
+	}{
+		{
+			name:    "raw secret",
+			secrets: []string{secretAPIKey},
+			in:      "Get https://api.openweathermap.org/data/2.5/weather?appid=" + secretAPIKey + ": EOF",
+			want:    "Get https://api.openweathermap.org/data/2.5/weather?appid=REDACTED: EOF",
+		},
+		{
+			name:    "URL-encoded secret",
+			secrets: []string{secretAPIKey},
+			in:      "appid=" + url.QueryEscape(secretAPIKey) + "&q=Mexico",
+			want:    "appid=REDACTED&q=Mexico",
+		},
+		{
+			name:    "multiple secrets and occurrences",
+			secrets: []string{"key-a", "key-b"},
+			in:      "key-a key-b key-a",
+			want:    "REDACTED REDACTED REDACTED",
+		},
+		{
+			name:    "no secrets",
+			secrets: []string{""},
+			in:      "nothing to hide",
+			want:    "nothing to hide",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := newRedactingWriter(&buf, test.secrets)
+			n, err := w.Write([]byte(test.in))
+			if err != nil {
+				t.Fatalf("Write(%q) returned unexpected error: %v", test.in, err)
+			}
+			if n != len(test.in) {
+				t.Errorf("Write(%q) returned %d bytes written, want %d", test.in, n, len(test.in))
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("Write(%q) wrote %q, want %q", test.in, got, test.want)
+			}
+		})
+	}
+}
+
+// TestOutputPaths_RedactAPIKey runs failing queries through the whole application, i.e: API client,
+// store, logs, text and JSON output, and checks the API key never makes it to any output.
+func TestOutputPaths_RedactAPIKey(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"unreachable service": nil,
+		"invalid API key": func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"cod": 401, "message": "Invalid API key ` + secretAPIKey + `"}`))
+		},
+		"response echoing the request": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.URL.String()))
+		},
+	}
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(handler)
+			if handler == nil {
+				server.Close()
+			} else {
+				defer server.Close()
+			}
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			ow, err := openweather.NewAPIClient(secretAPIKey, "metric", openweather.WithBaseURL(server.URL+"/"))
+			if err != nil {
+				t.Fatalf("NewAPIClient returned unexpected error: %v", err)
+			}
+			app := NewApp(&Deps{store: store.NewConcurrentStore(ow)})
+			results, err := app.GetCitiesWeather(context.Background(), []string{"Mexico City", "Toluca"}, []int{2, 3})
+			if err != nil {
+				t.Fatalf("GetCitiesWeather returned unexpected error: %v", err)
+			}
+			for _, r := range results.Unique() {
+				if !r.Report.Failed {
+					t.Fatalf("GetCitiesWeather returned successful report for %q, want failure", r.Key)
+				}
+			}
+
+			var text, jsonOut bytes.Buffer
+			writeTextResults(&text, results, openweather.Imperial, true)
+			if err := writeJSONResults(&jsonOut, results, openweather.Imperial); err != nil {
+				t.Fatalf("writeJSONResults returned unexpected error: %v", err)
+			}
+			assertNoSecret(t, "logs", logs.String())
+			assertNoSecret(t, "text output", text.String())
+			assertNoSecret(t, "JSON output", jsonOut.String())
+		})
+	}
+}
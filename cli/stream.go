@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/pablotrinidad/weatherreport/store"
+)
+
+// defaultBatchSize is the default number of distinct queries sent to the store at once when
+// streaming a dataset.
+const defaultBatchSize = 1000
+
+// queryBatch holds distinct queries read from a dataset, either airports or cities, along with the
+// row each one first appears at.
+type queryBatch struct {
+	airports []store.Airport
+	cities   []string
+	queries  []store.Query
+}
+
+// streamQueries reads the dataset at src row by row, sending batches of up to size queries that
+// don't repeat a query of previous rows to batches, which is closed once done. Columns are
+// addressed according to the layout resolve returns for the dataset header. It returns the total
+// number of queries read, duplicates included. Reading stops once ctx is cancelled or at the first
+// invalid row, only the set of distinct queries seen so far is kept in memory.
+func streamQueries(ctx context.Context, src string, in datasetInput, format datasetFormat, resolve func(header []string) (datasetLayout, error), size int, batches chan<- *queryBatch) (int, error) {
+	defer close(batches)
+	reader, err := openDataset(src, in)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	header, err := reader.Read()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	layout, err := resolve(header)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[string]bool)
+	queries := 0
+	batch := &queryBatch{}
+	send := func() bool {
+		select {
+		case batches <- batch:
+			batch = &queryBatch{}
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for ctx.Err() == nil {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return queries, err
+		}
+		line := reader.Row()
+		switch format {
+		case airportDatasetFormat:
+			pair, err := parseAirportsRow(layout, row, line)
+			if err != nil {
+				return queries, err
+			}
+			for _, airport := range pair {
+				queries++
+				if !seen[airport.Code] {
+					seen[airport.Code] = true
+					batch.airports = append(batch.airports, airport)
+					batch.queries = append(batch.queries, store.Query{Key: airport.Code, Row: line})
+				}
+			}
+		case citiesDatasetFormat:
+			city, err := parseCitiesRow(layout, row, line)
+			if err != nil {
+				return queries, err
+			}
+			queries++
+			if !seen[city] {
+				seen[city] = true
+				batch.cities = append(batch.cities, city)
+				batch.queries = append(batch.queries, store.Query{Key: city, Row: line})
+			}
+		default:
+			return queries, fmt.Errorf("got invalid dataset format %d, use 1 for airport codes dataset and 2 for city names dataset", format)
+		}
+		if len(batch.queries) >= size && !send() {
+			return queries, nil
+		}
+	}
+	if len(batch.queries) > 0 && ctx.Err() == nil {
+		send()
+	}
+	return queries, nil
+}
+
+// StreamWeather fetches the weather of the dataset at src while reading it, batch by batch, and
+// writes each distinct query result to out as soon as its batch is answered, along with the row it
+// first appears at. Memory is bounded by the batch size and the number of distinct queries rather
+// than by the dataset size. Queries whose batch was never read because ctx was cancelled are not
+// reported, the ones that were read but not attempted are returned.
+func (a *App) StreamWeather(ctx context.Context, src string, format datasetFormat, size int, out resultWriter) ([]string, error) {
+	log.Printf("streaming queries from %s in batches of %d", datasetName(src), size)
+	// Reading stops as soon as results can't be written anymore.
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	batches := make(chan *queryBatch, 1)
+	type readResult struct {
+		queries int
+		err     error
+	}
+	read := make(chan readResult, 1)
+	go func() {
+		resolve := func(header []string) (datasetLayout, error) {
+			return a.layout(format, header)
+		}
+		queries, err := streamQueries(readCtx, src, a.input, format, resolve, size, batches)
+		read <- readResult{queries: queries, err: err}
+	}()
+
+	start := time.Now()
+	var summary resultsSummary
+	var notAttempted []string
+	for b := range batches {
+		log.Printf("\nfetching weather information of %d queries...", len(b.queries))
+		var reports map[string]store.WeatherReport
+		if b.airports != nil {
+			reports = a.deps.store.GetWeatherByAirportCode(ctx, b.airports)
+		} else {
+			reports = a.deps.store.GetWeatherByCityName(ctx, b.cities)
+		}
+		results := store.NewResultSet(b.queries, reports)
+		for i := 0; i < results.Len(); i++ {
+			r := results.At(i)
+			summary.add(r.Report)
+			if r.Report.NotAttempted {
+				notAttempted = append(notAttempted, r.Key)
+			}
+			if err := out.write(r.Key, []int{r.Row}, r.Report); err != nil {
+				return notAttempted, err
+			}
+		}
+	}
+	res := <-read
+	if err := out.close(); err != nil {
+		return notAttempted, err
+	}
+	if res.err != nil {
+		return notAttempted, fmt.Errorf("failed reading dataset: %v", res.err)
+	}
+	summary.queries = res.queries
+	summary.print(time.Since(start))
+	printUsage(a.deps.store.GetAPIUsage())
+	return notAttempted, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pablotrinidad/weatherreport/store"
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+// batchesStore is a Store answering every query successfully that records the queries of each call.
+type batchesStore struct {
+	batches [][]string
+}
+
+func (s *batchesStore) GetWeatherByAirportCode(ctx context.Context, airports []store.Airport) map[string]store.WeatherReport {
+	codes := make([]string, len(airports))
+	for i, a := range airports {
+		codes[i] = a.Code
+	}
+	return s.GetWeatherByCityName(ctx, codes)
+}
+
+func (s *batchesStore) GetWeatherByCityName(_ context.Context, keys []string) map[string]store.WeatherReport {
+	s.batches = append(s.batches, keys)
+	reports := make(map[string]store.WeatherReport)
+	for _, k := range keys {
+		reports[k] = store.WeatherReport{CityName: k}
+	}
+	return reports
+}
+
+func (s *batchesStore) GetAPIUsage() store.APIUsage {
+	return store.APIUsage{}
+}
+
+func writeDataset(t *testing.T, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "stream")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir returned unexpected error: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	src := filepath.Join(dir, "dataset.csv")
+	if err := ioutil.WriteFile(src, []byte(content), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile returned unexpected error: %v", err)
+	}
+	return src
+}
+
+func TestApp_StreamWeather(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      datasetFormat
+		content     string
+		wantBatches [][]string
+		wantResults []jsonResult
+	}{
+		{
+			name:        "cities",
+			format:      citiesDatasetFormat,
+			content:     "city\nToluca\nMonterrey\nToluca\nTampico\nMonterrey\nPuebla\n",
+			wantBatches: [][]string{{"Toluca", "Monterrey"}, {"Tampico", "Puebla"}},
+			wantResults: []jsonResult{
+				{Query: "Toluca", Rows: []int{2}, Report: store.WeatherReport{CityName: "Toluca"}},
+				{Query: "Monterrey", Rows: []int{3}, Report: store.WeatherReport{CityName: "Monterrey"}},
+				{Query: "Tampico", Rows: []int{5}, Report: store.WeatherReport{CityName: "Tampico"}},
+				{Query: "Puebla", Rows: []int{7}, Report: store.WeatherReport{CityName: "Puebla"}},
+			},
+		},
+		{
+			name:   "airports",
+			format: airportDatasetFormat,
+			content: "origin,destination,origin_lat,origin_lon,destination_lat,destination_lon\n" +
+				"TLC,MEX,19.3371,-99.566,19.4363,-99.0721\n" +
+				"MEX,TLC,19.4363,-99.0721,19.3371,-99.566\n" +
+				"MTY,MEX,25.7785,-100.107,19.4363,-99.0721\n",
+			wantBatches: [][]string{{"TLC", "MEX"}, {"MTY"}},
+			wantResults: []jsonResult{
+				{Query: "TLC", Rows: []int{2}, Report: store.WeatherReport{CityName: "TLC"}},
+				{Query: "MEX", Rows: []int{2}, Report: store.WeatherReport{CityName: "MEX"}},
+				{Query: "MTY", Rows: []int{4}, Report: store.WeatherReport{CityName: "MTY"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &batchesStore{}
+			app := NewApp(&Deps{store: s})
+			var out bytes.Buffer
+
+			if _, err := app.StreamWeather(context.Background(), writeDataset(t, test.content), test.format, 2, newJSONResultWriter(&out, openweather.Metric)); err != nil {
+				t.Fatalf("StreamWeather returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(s.batches, test.wantBatches); diff != "" {
+				t.Errorf("got batches %v, want %v\ndiff: got->want %s", s.batches, test.wantBatches, diff)
+			}
+			var got []jsonResult
+			if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatalf("StreamWeather wrote invalid JSON: %v\n%s", err, out.String())
+			}
+			if diff := cmp.Diff(got, test.wantResults); diff != "" {
+				t.Errorf("got results %v, want %v\ndiff: got->want %s", got, test.wantResults, diff)
+			}
+		})
+	}
+}
+
+func TestApp_StreamWeather_invalidRow(t *testing.T) {
+	s := &batchesStore{}
+	app := NewApp(&Deps{store: s})
+	var out bytes.Buffer
+	src := writeDataset(t, "city\nToluca\nMonterrey\nTampico\n\"\"\nPuebla\n")
+
+	_, err := app.StreamWeather(context.Background(), src, citiesDatasetFormat, 2, newJSONResultWriter(&out, openweather.Metric))
+	if err == nil || !strings.Contains(err.Error(), "row 5") {
+		t.Fatalf("StreamWeather returned error %v, want empty city name at row 5", err)
+	}
+	// Results of the batches read before the invalid row are still written.
+	var got []jsonResult
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("StreamWeather wrote invalid JSON: %v\n%s", err, out.String())
+	}
+	if len(got) < 2 {
+		t.Errorf("got %d results, want at least the first batch", len(got))
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// invalidDatasetExitCode is returned by the validate command when the dataset has errors.
+const invalidDatasetExitCode = 2
+
+// coordinatesTolerance is how many degrees the coordinates of an airport may differ across rows
+// before being reported as inconsistent, about a kilometer.
+const coordinatesTolerance = 0.01
+
+type issueSeverity string
+
+const (
+	// errorSeverity issues make the dataset fail to load or produce wrong results.
+	errorSeverity issueSeverity = "error"
+	// warningSeverity issues are likely mistakes that don't prevent loading the dataset.
+	warningSeverity issueSeverity = "warning"
+)
+
+// datasetIssue is a problem found at a dataset row.
+type datasetIssue struct {
+	// Row is the dataset row, the header is row 1.
+	Row int `json:"row"`
+	// Column is the 1-based column, zero for issues of the whole row.
+	Column   int           `json:"column,omitempty"`
+	Severity issueSeverity `json:"severity"`
+	Message  string        `json:"message"`
+}
+
+func (i datasetIssue) String() string {
+	if i.Column == 0 {
+		return fmt.Sprintf("row %d: %s: %s", i.Row, i.Severity, i.Message)
+	}
+	return fmt.Sprintf("row %d, column %d: %s: %s", i.Row, i.Column, i.Severity, i.Message)
+}
+
+// validationReport is the result of validating a whole dataset.
+type validationReport struct {
+	Dataset  string         `json:"dataset"`
+	Rows     int            `json:"rows"`
+	Errors   int            `json:"errors"`
+	Warnings int            `json:"warnings"`
+	Issues   []datasetIssue `json:"issues"`
+}
+
+// datasetValidator accumulates the issues found in a dataset.
+type datasetValidator struct {
+	issues []datasetIssue
+}
+
+func (v *datasetValidator) errorf(row, column int, format string, args ...interface{}) {
+	v.issues = append(v.issues, datasetIssue{Row: row, Column: column, Severity: errorSeverity, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *datasetValidator) warnf(row, column int, format string, args ...interface{}) {
+	v.issues = append(v.issues, datasetIssue{Row: row, Column: column, Severity: warningSeverity, Message: fmt.Sprintf(format, args...)})
+}
+
+// report returns the validation report of a dataset with the given number of rows, issues are
+// sorted by row.
+func (v *datasetValidator) report(dataset string, rows int) *validationReport {
+	sort.SliceStable(v.issues, func(i, j int) bool { return v.issues[i].Row < v.issues[j].Row })
+	r := &validationReport{Dataset: dataset, Rows: rows, Issues: v.issues}
+	if r.Issues == nil {
+		r.Issues = []datasetIssue{}
+	}
+	for _, i := range v.issues {
+		if i.Severity == errorSeverity {
+			r.Errors++
+		} else {
+			r.Warnings++
+		}
+	}
+	return r
+}
+
+// duplicateRow warns about rows repeating an earlier one, seen maps rows to their dataset line.
+func (v *datasetValidator) duplicateRow(seen map[string]int, row []string, line int) {
+	key := strings.Join(row, "\x00")
+	if first, ok := seen[key]; ok {
+		v.warnf(line, 0, "duplicate of row %d", first)
+		return
+	}
+	seen[key] = line
+}
+
+// airportCoords are the coordinates of an airport and the row they were first read at.
+type airportCoords struct {
+	lat, lon float64
+	row      int
+}
+
+// validateAirports reports every issue found in the rows of an airports dataset, header excluded,
+// numbers being the number of each row.
+func validateAirports(v *datasetValidator, l datasetLayout, rows [][]string, numbers []int) {
+	seenRows := make(map[string]int)
+	coords := make(map[string]airportCoords)
+	for i, row := range rows {
+		line := numbers[i]
+		if row == nil {
+			// Malformed lines were already reported when reading them.
+			continue
+		}
+		v.duplicateRow(seenRows, row, line)
+		if len(row) < l.columns() {
+			v.errorf(line, 0, "missing columns, got %d want %d", len(row), l.columns())
+			continue
+		}
+		v.departure(l, row, line)
+		for _, c := range []struct{ code, lat, lon datasetField }{
+			{originField, originLatField, originLonField},
+			{destinationField, destinationLatField, destinationLonField},
+		} {
+			code := strings.TrimSpace(l.get(row, c.code))
+			if code == "" {
+				v.errorf(line, l.column(c.code), "empty %s", c.code)
+				continue
+			}
+			lat, latOK := v.coordinate(l, row, line, c.lat, 90)
+			lon, lonOK := v.coordinate(l, row, line, c.lon, 180)
+			if !latOK || !lonOK {
+				continue
+			}
+			prev, ok := coords[code]
+			if !ok {
+				coords[code] = airportCoords{lat: lat, lon: lon, row: line}
+				continue
+			}
+			if math.Abs(prev.lat-lat) > coordinatesTolerance || math.Abs(prev.lon-lon) > coordinatesTolerance {
+				v.warnf(line, l.column(c.code), "airport %s is at %0.4f,%0.4f but at %0.4f,%0.4f in row %d", code, lat, lon, prev.lat, prev.lon, prev.row)
+			}
+		}
+	}
+}
+
+// coordinate parses the coordinate field f of row and checks it's within [-max, max].
+func (v *datasetValidator) coordinate(l datasetLayout, row []string, line int, f datasetField, max float64) (float64, bool) {
+	value := l.get(row, f)
+	c, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		v.errorf(line, l.column(f), "invalid %s %q", f, value)
+		return 0, false
+	}
+	if c < -max || c > max || math.IsNaN(c) {
+		v.errorf(line, l.column(f), "%s %v out of range [-%v, %v]", f, c, max, max)
+		return 0, false
+	}
+	return c, true
+}
+
+// departure reports empty departures of row, if the dataset maps them.
+func (v *datasetValidator) departure(l datasetLayout, row []string, line int) {
+	if l[departureField] >= 0 && strings.TrimSpace(l.get(row, departureField)) == "" {
+		v.warnf(line, l.column(departureField), "empty %s", departureField)
+	}
+}
+
+// validateCities reports every issue found in the rows of a cities dataset, header excluded,
+// numbers being the number of each row.
+func validateCities(v *datasetValidator, l datasetLayout, rows [][]string, numbers []int) {
+	seenRows := make(map[string]int)
+	for i, row := range rows {
+		line := numbers[i]
+		if row == nil {
+			// Malformed lines were already reported when reading them.
+			continue
+		}
+		v.duplicateRow(seenRows, row, line)
+		if len(row) < l.columns() {
+			v.errorf(line, 0, "missing columns, got %d want %d", len(row), l.columns())
+			continue
+		}
+		v.departure(l, row, line)
+		city := strings.Trim(l.get(row, cityField), " \n")
+		if city == "" {
+			v.errorf(line, l.column(cityField), "empty %s", cityField)
+			continue
+		}
+		if reason := suspiciousCityName(city); reason != "" {
+			v.warnf(line, l.column(cityField), "suspicious city name %q: %s", city, reason)
+		}
+	}
+}
+
+// suspiciousCityName returns why the given city name is unlikely to be recognized by OpenWeather,
+// empty if it looks fine.
+func suspiciousCityName(city string) string {
+	letters := 0
+	for _, r := range city {
+		switch {
+		case unicode.IsLetter(r) || unicode.Is(unicode.Mn, r):
+			letters++
+		case unicode.IsDigit(r):
+			return "contains digits"
+		case strings.ContainsRune(" .-,()'‘’", r):
+		default:
+			return fmt.Sprintf("contains unexpected character %q", r)
+		}
+	}
+	if letters < 2 {
+		return "too short"
+	}
+	if strings.Contains(city, "  ") {
+		return "contains repeated spaces"
+	}
+	return ""
+}
+
+// readDatasetRows reads the header and every row of the dataset at src along with the number of each
+// row, reporting malformed lines as issues instead of stopping at the first one.
+func readDatasetRows(v *datasetValidator, src string, in datasetInput) ([]string, [][]string, []int, error) {
+	reader, err := openDataset(src, in)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer reader.Close()
+	var header []string
+	var rows [][]string
+	var numbers []int
+	first := true
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			if first {
+				v.errorf(1, 0, "malformed CSV header: %v", parseErr.Err)
+				first = false
+				continue
+			}
+			v.errorf(parseErr.StartLine, 0, "malformed CSV: %v", parseErr.Err)
+			// Malformed rows are still counted as dataset rows.
+			rows = append(rows, nil)
+			numbers = append(numbers, parseErr.StartLine)
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if first {
+			header, first = row, false
+			continue
+		}
+		rows = append(rows, row)
+		numbers = append(numbers, reader.Row())
+	}
+	return header, rows, numbers, nil
+}
+
+// validateDataset returns the validation report of the dataset at src, its columns are read as
+// told by the given mapping or, if nil, the default one of the format.
+func validateDataset(src string, in datasetInput, format datasetFormat, columns columnMapping) (*validationReport, error) {
+	if format != airportDatasetFormat && format != citiesDatasetFormat {
+		return nil, fmt.Errorf("got invalid dataset format %d, use 1 for airport codes dataset and 2 for city names dataset", format)
+	}
+	if columns == nil {
+		columns = defaultColumnMappings[format]
+	}
+	v := &datasetValidator{}
+	header, rows, numbers, err := readDatasetRows(v, src, in)
+	if err != nil {
+		return nil, err
+	}
+	l, err := columns.resolve(header)
+	if err != nil {
+		// Rows can't be checked without knowing where their fields are.
+		v.errorf(1, 0, "%v", err)
+		return v.report(datasetName(src), len(rows)), nil
+	}
+	if format == airportDatasetFormat {
+		validateAirports(v, l, rows, numbers)
+	} else {
+		validateCities(v, l, rows, numbers)
+	}
+	return v.report(datasetName(src), len(rows)), nil
+}
+
+// writeValidationReport writes r to w as human readable text.
+func writeValidationReport(w io.Writer, r *validationReport) {
+	for _, i := range r.Issues {
+		fmt.Fprintln(w, i)
+	}
+	fmt.Fprintf(w, "%s: %d rows, %d errors, %d warnings\n", r.Dataset, r.Rows, r.Errors, r.Warnings)
+}
+
+// runValidateCommand reports every issue of a dataset and returns whether it has no errors.
+func runValidateCommand(args []string) (bool, error) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	var dataset, output string
+	var format uint
+	fs.StringVar(&dataset, "d", "", "path to dataset location, - to read it from the standard input")
+	fs.UintVar(&format, "f", 0, datasetFormatUsage)
+	fs.StringVar(&output, "o", string(textOutputFormat), "output format [text,json]")
+	columnsOpt := registerColumnsFlags(fs)
+	inputOpt := registerInputFlags(fs)
+	fs.Parse(args)
+	if dataset == "" {
+		return false, fmt.Errorf("cannot use empty dataset location")
+	}
+	columns, err := columnsOpt()
+	if err != nil {
+		return false, err
+	}
+	in, err := inputOpt()
+	if err != nil {
+		return false, err
+	}
+	switch outputFormat(output) {
+	case textOutputFormat, jsonOutputFormat:
+	default:
+		return false, fmt.Errorf("got invalid output format %q, use text or json", output)
+	}
+
+	f, err := resolveDatasetFormat(dataset, in, datasetFormat(format), columns)
+	if err != nil {
+		return false, err
+	}
+	r, err := validateDataset(dataset, in, f, columns)
+	if err != nil {
+		return false, err
+	}
+	if outputFormat(output) == textOutputFormat {
+		writeValidationReport(os.Stdout, r)
+		return r.Errors == 0, nil
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r); err != nil {
+		return false, err
+	}
+	return r.Errors == 0, nil
+}
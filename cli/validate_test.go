@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateDataset(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     datasetFormat
+		columns    columnMapping
+		content    string
+		wantIssues []datasetIssue
+	}{
+		{
+			name:   "valid airports",
+			format: airportDatasetFormat,
+			content: "origin,destination,origin_lat,origin_lon,destination_lat,destination_lon\n" +
+				"TLC,MEX,19.3371,-99.566,19.4363,-99.0721\n" +
+				"MEX,MTY,19.4363,-99.0721,25.7785,-100.107\n",
+			wantIssues: []datasetIssue{},
+		},
+		{
+			name:   "every airports issue is reported",
+			format: airportDatasetFormat,
+			content: "origin,destination,origin_lat,origin_lon,destination_lat,destination_lon\n" +
+				"TLC,MEX,19.3371,-99.566\n" +
+				",MEX,19.3371,-99.566,19.4363,-99.0721\n" +
+				"TLC,MEX,north,-99.566,91,-99.0721\n" +
+				"TLC,MEX,19.3371,-99.566,19.4363,-199.0721\n" +
+				"MTY,MEX,25.7785,-100.107,25.7785,-100.107\n" +
+				"MTY,MEX,25.7785,-100.107,25.7785,-100.107\n",
+			wantIssues: []datasetIssue{
+				{Row: 2, Severity: errorSeverity, Message: "missing columns, got 4 want 6"},
+				{Row: 3, Column: 1, Severity: errorSeverity, Message: "empty origin airport code"},
+				{Row: 4, Column: 3, Severity: errorSeverity, Message: `invalid origin lat "north"`},
+				{Row: 4, Column: 5, Severity: errorSeverity, Message: "destination lat 91 out of range [-90, 90]"},
+				{Row: 5, Column: 6, Severity: errorSeverity, Message: "destination lon -199.0721 out of range [-180, 180]"},
+				{Row: 6, Column: 2, Severity: warningSeverity, Message: "airport MEX is at 25.7785,-100.1070 but at 19.4363,-99.0721 in row 3"},
+				{Row: 7, Severity: warningSeverity, Message: "duplicate of row 6"},
+				{Row: 7, Column: 2, Severity: warningSeverity, Message: "airport MEX is at 25.7785,-100.1070 but at 19.4363,-99.0721 in row 3"},
+			},
+		},
+		{
+			name:    "mapped airports columns",
+			format:  airportDatasetFormat,
+			columns: columnMapping{originField: "From", destinationField: "To", originLatField: "#4", originLonField: "#5", destinationLatField: "#6", destinationLonField: "#7", departureField: "Departs"},
+			content: "Departs,To,From,from_lat,from_lon,to_lat,to_lon\n" +
+				"2020-03-01 10:00,MEX,TLC,19.3371,-99.566,19.4363,-99.0721\n" +
+				",MEX,TLC,19.3371,-99.566,19.4363,-99.0721\n" +
+				"2020-03-01 12:00,,TLC,19.3371,-99.566,19.4363,-99.0721\n" +
+				"2020-03-01 13:00,MEX,TLC,19.3371,-99.566,91,-99.0721\n",
+			wantIssues: []datasetIssue{
+				{Row: 3, Column: 1, Severity: warningSeverity, Message: "empty departure"},
+				{Row: 4, Column: 2, Severity: errorSeverity, Message: "empty destination airport code"},
+				{Row: 5, Column: 6, Severity: errorSeverity, Message: "destination lat 91 out of range [-90, 90]"},
+			},
+		},
+		{
+			name:       "mapped column missing from the header",
+			format:     citiesDatasetFormat,
+			columns:    columnMapping{cityField: "Town"},
+			content:    "city\nToluca\n",
+			wantIssues: []datasetIssue{{Row: 1, Severity: errorSeverity, Message: `failed mapping city: column "Town" not found in header "city"`}},
+		},
+		{
+			name:   "every cities issue is reported",
+			format: citiesDatasetFormat,
+			content: "city\n" +
+				"Toluca\n" +
+				"\" \"\n" +
+				"Monterrey\n" +
+				"Toluca\n" +
+				"Tampico 42\n" +
+				"Ciudad de México\n" +
+				"Kil’mez’\n" +
+				"X\n" +
+				"Bad \"quote\n",
+			wantIssues: []datasetIssue{
+				{Row: 3, Column: 1, Severity: errorSeverity, Message: "empty city name"},
+				{Row: 5, Severity: warningSeverity, Message: "duplicate of row 2"},
+				{Row: 6, Column: 1, Severity: warningSeverity, Message: `suspicious city name "Tampico 42": contains digits`},
+				{Row: 9, Column: 1, Severity: warningSeverity, Message: `suspicious city name "X": too short`},
+				{Row: 10, Severity: errorSeverity, Message: `malformed CSV: bare " in non-quoted-field`},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "validate")
+			if err != nil {
+				t.Fatalf("ioutil.TempDir returned unexpected error: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			src := filepath.Join(dir, "dataset.csv")
+			if err := ioutil.WriteFile(src, []byte(test.content), 0644); err != nil {
+				t.Fatalf("ioutil.WriteFile returned unexpected error: %v", err)
+			}
+
+			r, err := validateDataset(src, datasetInput{}, test.format, test.columns)
+			if err != nil {
+				t.Fatalf("validateDataset returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(r.Issues, test.wantIssues); diff != "" {
+				t.Errorf("got issues %v, want %v\ndiff: got->want %s", r.Issues, test.wantIssues, diff)
+			}
+		})
+	}
+}
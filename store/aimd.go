@@ -0,0 +1,68 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+const (
+	// aimdDecreaseFactor is applied to the rate upon rate-limited or server errors.
+	aimdDecreaseFactor = 0.5
+	// aimdBackoffWindow is how long errors are ignored after decreasing the rate, so a burst of
+	// errors from calls already in flight only halves the rate once.
+	aimdBackoffWindow = 5 * time.Second
+)
+
+// aimdController adapts a requests per minute rate following an additive-increase/multiplicative-
+// decrease policy: the rate is halved when the API signals overload (rate-limited or server
+// errors) and it ramps up by roughly one request per minute for every minute of successful calls,
+// never going above max nor below one request per minute.
+type aimdController struct {
+	mu    sync.Mutex
+	clock clock
+	rate  float64
+	max   float64
+	// lastDecrease is the time the rate was last decreased.
+	lastDecrease time.Time
+}
+
+// newAIMDController returns a controller starting at max requests per minute.
+func newAIMDController(c clock, max int) *aimdController {
+	return &aimdController{clock: c, rate: float64(max), max: float64(max)}
+}
+
+// record adapts the rate to the result of a call and returns whether the rate was decreased.
+func (a *aimdController) record(err error) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case err == nil:
+		// A full rate worth of successful calls increases the rate by one.
+		a.rate = math.Min(a.max, a.rate+1/a.rate)
+	case overloaded(err):
+		now := a.clock.Now()
+		if now.Before(a.lastDecrease.Add(aimdBackoffWindow)) {
+			return false
+		}
+		a.lastDecrease = now
+		a.rate = math.Max(1, a.rate*aimdDecreaseFactor)
+		return true
+	}
+	return false
+}
+
+// limit returns the current rate in whole requests per minute.
+func (a *aimdController) limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int(a.rate)
+}
+
+// overloaded returns whether err signals the API is receiving more calls than it can take.
+func overloaded(err error) bool {
+	return errors.Is(err, openweather.ErrRateLimited) || errors.Is(err, openweather.ErrServer)
+}
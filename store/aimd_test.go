@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+func TestAIMDController(t *testing.T) {
+	rateLimited := fmt.Errorf("wrapped: %w", openweather.ErrRateLimited)
+	serverError := fmt.Errorf("%w: 503 Service Unavailable", openweather.ErrServer)
+	tests := []struct {
+		name string
+		max  int
+		// results are recorded in order, advancing the clock by wait before each one.
+		results      []error
+		wait         time.Duration
+		wantLimit    int
+		wantDecrease int
+	}{
+		{name: "successful calls keep the maximum rate", max: 60, results: repeatErr(nil, 100), wantLimit: 60},
+		{name: "rate-limited halves the rate", max: 60, results: []error{rateLimited}, wantLimit: 30, wantDecrease: 1},
+		{name: "server error halves the rate", max: 60, results: []error{serverError}, wantLimit: 30, wantDecrease: 1},
+		{name: "other errors keep the rate", max: 60, results: []error{openweather.ErrNotFound, errors.New("boom")}, wantLimit: 60},
+		{
+			name:         "burst of errors halves the rate once",
+			max:          60,
+			results:      []error{rateLimited, rateLimited, serverError},
+			wait:         time.Second,
+			wantLimit:    30,
+			wantDecrease: 1,
+		},
+		{
+			name:         "errors apart from each other keep halving the rate",
+			max:          60,
+			results:      []error{rateLimited, rateLimited, rateLimited},
+			wait:         aimdBackoffWindow,
+			wantLimit:    7,
+			wantDecrease: 3,
+		},
+		{
+			name:         "rate never drops below one call per minute",
+			max:          2,
+			results:      []error{rateLimited, rateLimited, rateLimited},
+			wait:         time.Minute,
+			wantLimit:    1,
+			wantDecrease: 3,
+		},
+		{
+			name:         "successful calls slowly ramp up the rate",
+			max:          60,
+			results:      append([]error{rateLimited}, repeatErr(nil, 40)...),
+			wantLimit:    31,
+			wantDecrease: 1,
+		},
+		{
+			name:         "rate recovers up to the maximum",
+			max:          4,
+			results:      append([]error{rateLimited}, repeatErr(nil, 100)...),
+			wantLimit:    4,
+			wantDecrease: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClock()
+			a := newAIMDController(c, test.max)
+			decreases := 0
+			for _, err := range test.results {
+				c.Advance(test.wait)
+				if a.record(err) {
+					decreases++
+				}
+			}
+			if got := a.limit(); got != test.wantLimit {
+				t.Errorf("limit() returned %d, want %d", got, test.wantLimit)
+			}
+			if decreases != test.wantDecrease {
+				t.Errorf("record() decreased the rate %d times, want %d", decreases, test.wantDecrease)
+			}
+		})
+	}
+}
+
+func repeatErr(err error, n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+func TestConcurrentStore_AdaptiveRate(t *testing.T) {
+	api := &fakeKeyedAPI{id: "a", errs: []error{openweather.ErrRateLimited}}
+	s := NewConcurrentStore(api, WithRequestsPerMinute(10), WithConcurrency(1))
+
+	s.GetWeatherByCityName(context.Background(), []string{"Toluca", "Monterrey"})
+	got := s.GetAPIUsage()
+	if got.RequestsPerMinute != 5 {
+		t.Errorf("GetAPIUsage().RequestsPerMinute after a rate-limited call returned %d, want 5", got.RequestsPerMinute)
+	}
+	if got.SuccessfulCalls != 1 || got.FailedCalls != 1 {
+		t.Errorf("GetAPIUsage() returned %d successful and %d failed calls, want 1 and 1", got.SuccessfulCalls, got.FailedCalls)
+	}
+}
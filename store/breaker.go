@@ -0,0 +1,226 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+// ErrCircuitOpen is matched by errors returned by CircuitBreaker while refusing calls, use
+// errors.Is to check for it and errors.As with *CircuitOpenError for details.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError is returned by CircuitBreaker for calls short-circuited while it is open.
+type CircuitOpenError struct {
+	// Cause is the error that opened the circuit.
+	Cause error
+	// RetryAt is when the circuit half-opens and lets a call through again.
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v until %s after: %v", ErrCircuitOpen, e.RetryAt.Format(time.RFC3339), e.Cause)
+}
+
+// Is makes errors.Is(err, ErrCircuitOpen) report true. The cause is deliberately not unwrapped so
+// short-circuited calls are not mistaken for calls rejected by the API.
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every call through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen short-circuits every call.
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe call through to check whether the API recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitTransition describes a CircuitBreaker state change.
+type CircuitTransition struct {
+	From, To CircuitState
+	// Cause is the error that led to the transition, nil when closing the circuit.
+	Cause error
+}
+
+// CircuitBreaker is an openweather.API decorator that stops calling the API once it looks down or
+// unusable. It opens after threshold consecutive server, rate-limited or network errors, or right
+// away on unauthorized errors. While open, calls fail immediately with a *CircuitOpenError. After
+// the cooldown a single probe call is let through (half-open): the circuit closes if it doesn't
+// fail and opens again otherwise.
+type CircuitBreaker struct {
+	api      openweather.API
+	clock    clock
+	notify   func(CircuitTransition)
+	cooldown time.Duration
+	// threshold is the number of consecutive failures that open the circuit.
+	threshold int
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	// cause is the error that last opened the circuit.
+	cause   error
+	retryAt time.Time
+	// probing is set while the half-open probe call is in flight.
+	probing bool
+	// epoch counts state transitions, results of calls allowed on previous epochs are stale.
+	epoch uint64
+}
+
+// NewCircuitBreaker returns a circuit breaker around api. notify, if not nil, is called with every
+// state transition while the breaker is locked, so it must not call the breaker back.
+func NewCircuitBreaker(api openweather.API, threshold int, cooldown time.Duration, notify func(CircuitTransition)) (*CircuitBreaker, error) {
+	return newCircuitBreaker(realClock{}, api, threshold, cooldown, notify)
+}
+
+func newCircuitBreaker(c clock, api openweather.API, threshold int, cooldown time.Duration, notify func(CircuitTransition)) (*CircuitBreaker, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("got invalid circuit breaker threshold %d, want a positive number", threshold)
+	}
+	if cooldown <= 0 {
+		return nil, fmt.Errorf("got invalid circuit breaker cooldown %s, want a positive duration", cooldown)
+	}
+	return &CircuitBreaker{api: api, clock: c, notify: notify, cooldown: cooldown, threshold: threshold}, nil
+}
+
+// GetWeatherByCoords returns the current weather at the given location unless the circuit is open.
+func (b *CircuitBreaker) GetWeatherByCoords(lat, lon float64) (*openweather.WeatherItem, error) {
+	return b.call(func() (*openweather.WeatherItem, error) {
+		return b.api.GetWeatherByCoords(lat, lon)
+	})
+}
+
+// GetWeatherByCityName returns the current weather at the given city name unless the circuit is open.
+func (b *CircuitBreaker) GetWeatherByCityName(cityName string) (*openweather.WeatherItem, error) {
+	return b.call(func() (*openweather.WeatherItem, error) {
+		return b.api.GetWeatherByCityName(cityName)
+	})
+}
+
+// State returns the current circuit state.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// KeyUsage returns the per-key usage statistics of the decorated API, if it provides them.
+func (b *CircuitBreaker) KeyUsage() []KeyUsage {
+	if p, ok := b.api.(interface{ KeyUsage() []KeyUsage }); ok {
+		return p.KeyUsage()
+	}
+	return nil
+}
+
+// call performs f if the circuit allows it and records its result.
+func (b *CircuitBreaker) call(f func() (*openweather.WeatherItem, error)) (*openweather.WeatherItem, error) {
+	epoch, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+	item, err := f()
+	b.record(epoch, err)
+	return item, err
+}
+
+// allow returns a *CircuitOpenError if the call must be short-circuited, otherwise the epoch the
+// call is allowed on, to be given back along with its result to record.
+func (b *CircuitBreaker) allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && !b.clock.Now().Before(b.retryAt) {
+		b.transition(CircuitHalfOpen, b.cause)
+	}
+	switch {
+	case b.state == CircuitOpen:
+		return 0, &CircuitOpenError{Cause: b.cause, RetryAt: b.retryAt}
+	case b.state == CircuitHalfOpen && b.probing:
+		// Only the probe call goes through while half-open.
+		return 0, &CircuitOpenError{Cause: b.cause, RetryAt: b.retryAt}
+	case b.state == CircuitHalfOpen:
+		b.probing = true
+	}
+	return b.epoch, nil
+}
+
+// record updates the circuit state with the result of a call allowed on the given epoch. Results of
+// calls allowed before the circuit last changed state are ignored, e.g: a call allowed while closed
+// finishing once half-open isn't the probe.
+func (b *CircuitBreaker) record(epoch uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if epoch != b.epoch {
+		return
+	}
+	b.probing = false
+	trip, failure := breakerFailure(err)
+	switch {
+	case !failure:
+		b.failures = 0
+		if b.state != CircuitClosed {
+			b.transition(CircuitClosed, nil)
+		}
+		return
+	case b.state == CircuitHalfOpen:
+		// The probe failed, the API hasn't recovered yet.
+		trip = true
+	}
+	b.failures++
+	if trip || b.failures >= b.threshold {
+		b.cause = err
+		b.retryAt = b.clock.Now().Add(b.cooldown)
+		b.transition(CircuitOpen, err)
+	}
+}
+
+// transition changes the circuit state and notifies it. b.mu must be held.
+func (b *CircuitBreaker) transition(to CircuitState, cause error) {
+	from := b.state
+	b.state = to
+	if from != to {
+		b.epoch++
+	}
+	if to == CircuitClosed {
+		b.failures = 0
+	}
+	if b.notify != nil && from != to {
+		b.notify(CircuitTransition{From: from, To: to, Cause: cause})
+	}
+}
+
+// breakerFailure returns whether err counts as a failure towards opening the circuit and whether
+// it must open the circuit right away. Errors about a single query, e.g: not found or invalid
+// responses, show the API is up and are not failures.
+func breakerFailure(err error) (trip, failure bool) {
+	var urlErr *url.Error
+	switch {
+	case err == nil:
+		return false, false
+	case errors.Is(err, openweather.ErrUnauthorized), errors.Is(err, ErrNoUsableKeys):
+		return true, true
+	case errors.Is(err, openweather.ErrServer), errors.Is(err, openweather.ErrRateLimited), errors.As(err, &urlErr):
+		return false, true
+	}
+	return false, false
+}
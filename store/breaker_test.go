@@ -0,0 +1,252 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+func TestNewCircuitBreaker(t *testing.T) {
+	api := &fakeKeyedAPI{id: "a"}
+	if _, err := NewCircuitBreaker(api, 0, time.Second, nil); err == nil {
+		t.Errorf("NewCircuitBreaker(api, 0, 1s, nil) returned nil error, want error")
+	}
+	if _, err := NewCircuitBreaker(api, 1, 0, nil); err == nil {
+		t.Errorf("NewCircuitBreaker(api, 1, 0, nil) returned nil error, want error")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	serverErr := fmt.Errorf("%w: 503 Service Unavailable", openweather.ErrServer)
+	networkErr := &url.Error{Op: "Get", URL: "https://example.com", Err: errors.New("connection refused")}
+	tests := []struct {
+		name      string
+		threshold int
+		errs      []error
+		// calls performed through the breaker.
+		calls     int
+		wantState CircuitState
+		// wantAPICalls is the number of calls that reached the API.
+		wantAPICalls int
+	}{
+		{
+			name:         "failures below threshold",
+			threshold:    3,
+			errs:         []error{serverErr, openweather.ErrRateLimited},
+			calls:        2,
+			wantState:    CircuitClosed,
+			wantAPICalls: 2,
+		},
+		{
+			name:         "consecutive failures open the circuit",
+			threshold:    3,
+			errs:         []error{serverErr, openweather.ErrRateLimited, networkErr, serverErr},
+			calls:        5,
+			wantState:    CircuitOpen,
+			wantAPICalls: 3,
+		},
+		{
+			name:         "unauthorized opens the circuit right away",
+			threshold:    3,
+			errs:         []error{openweather.ErrUnauthorized},
+			calls:        4,
+			wantState:    CircuitOpen,
+			wantAPICalls: 1,
+		},
+		{
+			name:         "no usable keys opens the circuit right away",
+			threshold:    3,
+			errs:         []error{fmt.Errorf("%w, last error: %v", ErrNoUsableKeys, openweather.ErrUnauthorized)},
+			calls:        2,
+			wantState:    CircuitOpen,
+			wantAPICalls: 1,
+		},
+		{
+			name:         "query errors reset failures",
+			threshold:    3,
+			errs:         []error{serverErr, serverErr, openweather.ErrNotFound, serverErr, serverErr, &openweather.DecodeError{Field: "main"}},
+			calls:        6,
+			wantState:    CircuitClosed,
+			wantAPICalls: 6,
+		},
+		{
+			name:         "successful calls reset failures",
+			threshold:    2,
+			errs:         []error{serverErr, nil, serverErr},
+			calls:        3,
+			wantState:    CircuitClosed,
+			wantAPICalls: 3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &fakeKeyedAPI{id: "a", errs: test.errs}
+			b, err := newCircuitBreaker(newFakeClock(), api, test.threshold, time.Minute, nil)
+			if err != nil {
+				t.Fatalf("newCircuitBreaker() returned unexpected error: %v", err)
+			}
+			for i := 0; i < test.calls; i++ {
+				b.GetWeatherByCityName("Toluca")
+			}
+			if got := b.State(); got != test.wantState {
+				t.Errorf("State() returned %v, want %v", got, test.wantState)
+			}
+			if api.calls != test.wantAPICalls {
+				t.Errorf("got %d API calls, want %d", api.calls, test.wantAPICalls)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker_openError(t *testing.T) {
+	c := newFakeClock()
+	b, _ := newCircuitBreaker(c, &fakeKeyedAPI{id: "a", errs: []error{openweather.ErrUnauthorized}}, 1, time.Minute, nil)
+	b.GetWeatherByCoords(1, 2)
+
+	_, err := b.GetWeatherByCoords(1, 2)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("GetWeatherByCoords(1, 2) returned error %v, want %v", err, ErrCircuitOpen)
+	}
+	// Short-circuited calls must not look like calls rejected by the API, e.g: KeyPool would disable keys.
+	if errors.Is(err, openweather.ErrUnauthorized) {
+		t.Errorf("GetWeatherByCoords(1, 2) returned error %v matching %v", err, openweather.ErrUnauthorized)
+	}
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("GetWeatherByCoords(1, 2) returned error %T, want *CircuitOpenError", err)
+	}
+	if openErr.Cause != openweather.ErrUnauthorized {
+		t.Errorf("got cause %v, want %v", openErr.Cause, openweather.ErrUnauthorized)
+	}
+	if want := c.Now().Add(time.Minute); !openErr.RetryAt.Equal(want) {
+		t.Errorf("got retry at %s, want %s", openErr.RetryAt, want)
+	}
+}
+
+func TestCircuitBreaker_halfOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		// probeErr is the result of the call performed once half-open.
+		probeErr        error
+		wantState       CircuitState
+		wantTransitions []CircuitTransition
+	}{
+		{
+			name:      "successful probe closes the circuit",
+			wantState: CircuitClosed,
+			wantTransitions: []CircuitTransition{
+				{From: CircuitClosed, To: CircuitOpen, Cause: openweather.ErrServer},
+				{From: CircuitOpen, To: CircuitHalfOpen, Cause: openweather.ErrServer},
+				{From: CircuitHalfOpen, To: CircuitClosed},
+			},
+		},
+		{
+			name:      "failed probe opens the circuit again",
+			probeErr:  openweather.ErrRateLimited,
+			wantState: CircuitOpen,
+			wantTransitions: []CircuitTransition{
+				{From: CircuitClosed, To: CircuitOpen, Cause: openweather.ErrServer},
+				{From: CircuitOpen, To: CircuitHalfOpen, Cause: openweather.ErrServer},
+				{From: CircuitHalfOpen, To: CircuitOpen, Cause: openweather.ErrRateLimited},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClock()
+			var got []CircuitTransition
+			api := &fakeKeyedAPI{id: "a", errs: []error{openweather.ErrServer, openweather.ErrServer, test.probeErr}}
+			b, _ := newCircuitBreaker(c, api, 2, time.Minute, func(tr CircuitTransition) {
+				got = append(got, tr)
+			})
+			b.GetWeatherByCityName("Toluca")
+			b.GetWeatherByCityName("Toluca")
+
+			c.Advance(59 * time.Second)
+			if _, err := b.GetWeatherByCityName("Toluca"); !errors.Is(err, ErrCircuitOpen) {
+				t.Errorf("GetWeatherByCityName() before the cooldown returned error %v, want %v", err, ErrCircuitOpen)
+			}
+			c.Advance(time.Second)
+			probe, err := b.allow()
+			if err != nil {
+				t.Fatalf("allow() after the cooldown returned unexpected error: %v", err)
+			}
+			// A single probe goes through while half-open.
+			if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+				t.Errorf("allow() while probing returned error %v, want %v", err, ErrCircuitOpen)
+			}
+			_, err = api.GetWeatherByCityName("Toluca")
+			b.record(probe, err)
+
+			if got := b.State(); got != test.wantState {
+				t.Errorf("State() returned %v, want %v", got, test.wantState)
+			}
+			if diff := cmp.Diff(got, test.wantTransitions, cmp.Comparer(func(a, b error) bool { return a == b })); diff != "" {
+				t.Errorf("got transitions %v, want %v\ndiff: got->want %s", got, test.wantTransitions, diff)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker_staleResults(t *testing.T) {
+	c := newFakeClock()
+	b, _ := newCircuitBreaker(c, &fakeKeyedAPI{id: "a"}, 1, time.Minute, nil)
+	// Allowed while closed, these calls are still in flight once the circuit opens.
+	slowSuccess, _ := b.allow()
+	slowFailure, _ := b.allow()
+	failed, _ := b.allow()
+	b.record(failed, openweather.ErrServer)
+
+	c.Advance(time.Minute)
+	probe, err := b.allow()
+	if err != nil {
+		t.Fatalf("allow() after the cooldown returned unexpected error: %v", err)
+	}
+	// Finishing while half-open, they're not the probe and don't change the circuit state.
+	b.record(slowSuccess, nil)
+	b.record(slowFailure, openweather.ErrServer)
+	if got := b.State(); got != CircuitHalfOpen {
+		t.Errorf("State() after stale results returned %v, want %v", got, CircuitHalfOpen)
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("allow() while probing returned error %v, want %v", err, ErrCircuitOpen)
+	}
+
+	b.record(probe, nil)
+	if got := b.State(); got != CircuitClosed {
+		t.Errorf("State() after a successful probe returned %v, want %v", got, CircuitClosed)
+	}
+}
+
+func TestConcurrentStore_WithCircuitBreaker(t *testing.T) {
+	c := newFakeClock()
+	start := c.Now()
+	api := &fakeKeyedAPI{id: "a", errs: []error{openweather.ErrUnauthorized}}
+	b, _ := newCircuitBreaker(c, api, 5, time.Hour, nil)
+	s := NewConcurrentStore(b, WithRequestsPerMinute(1), WithConcurrency(1), withClock(c))
+
+	got := s.GetWeatherByCityName(context.Background(), []string{"Toluca", "Monterrey", "Tampico", "Mexico City", "Puebla"})
+	if api.calls != 1 {
+		t.Errorf("got %d API calls, want 1", api.calls)
+	}
+	for k, r := range got {
+		if !r.Failed {
+			t.Errorf("got successful report for %q, want failed", k)
+		}
+	}
+	// Short-circuited calls don't count towards the rate limit, only the first call is throttled.
+	if elapsed := c.Now().Sub(start); elapsed > time.Minute {
+		t.Errorf("fetching took %s, want at most 1m", elapsed)
+	}
+	// Nor do they look like failed API calls.
+	usage := s.GetAPIUsage()
+	if usage.FailedCalls != 1 || usage.ShortCircuited != 4 || usage.SuccessfulCalls != 0 {
+		t.Errorf("got %d successful, %d failed and %d short-circuited calls, want 0, 1 and 4", usage.SuccessfulCalls, usage.FailedCalls, usage.ShortCircuited)
+	}
+}
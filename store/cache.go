@@ -0,0 +1,425 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotCached is the failure of queries answered offline that were never cached.
+var ErrNotCached = errors.New("not cached, cannot be fetched in offline mode")
+
+// CacheStats contains CachedStore statistics.
+type CacheStats struct {
+	// Hits count, i.e: distinct queries answered from the cache.
+	Hits uint
+	// Misses count, i.e: distinct queries passed on to the decorated store, including expired ones.
+	Misses uint
+	// Evictions count, i.e: entries removed to make room for newer ones.
+	Evictions uint
+	// Entries currently cached.
+	Entries int
+}
+
+// cacheEntry is a cached report.
+type cacheEntry struct {
+	Key       string        `json:"key"`
+	Report    WeatherReport `json:"report"`
+	FetchedAt time.Time     `json:"fetched_at"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+// CachedStore is a Store decorator that keeps reports in memory so repeated queries don't reach
+// the decorated store. Successful reports are cached for ttl and reports of locations that don't
+// exist for notFoundTTL, other failures are never cached. Once maxEntries are cached, the least
+// recently used entry is evicted to make room for new ones. Expired entries are kept until
+// replaced or evicted, so they can still be used offline.
+//
+// Reports answered from the cache have their Age set. Entries can be persisted across executions
+// with Save and Load.
+type CachedStore struct {
+	store       Store
+	clock       clock
+	maxEntries  int
+	ttl         time.Duration
+	notFoundTTL time.Duration
+	// offline answers every query from the cache regardless of TTLs, never using store.
+	offline bool
+	// language of the reports answered, empty for the API default language (English).
+	language string
+	// staleAfter is the age past which observations answered are flagged as stale, zero disables
+	// the check.
+	staleAfter time.Duration
+
+	mu sync.Mutex
+	// entries holds *cacheEntry values, most recently used first.
+	entries *list.List
+	// index maps query keys to their element in entries.
+	index map[string]*list.Element
+	stats CacheStats
+}
+
+// CacheOption configures a CachedStore.
+type CacheOption func(*CachedStore)
+
+// WithCacheLanguage makes the cache answer reports in the given language only, the one s fetches
+// them in. Entries of other languages are kept but never used. Defaults to the API default language.
+func WithCacheLanguage(lang string) CacheOption {
+	return func(c *CachedStore) {
+		// Languages are lowercased like openweather.WithLanguage does.
+		c.language = strings.ToLower(lang)
+	}
+}
+
+// WithCacheStaleAfter flags reports answered from the cache whose observation is older than d by
+// then as stale, whatever they were flagged as when fetched.
+func WithCacheStaleAfter(d time.Duration) CacheOption {
+	return func(c *CachedStore) {
+		c.staleAfter = d
+	}
+}
+
+// NewCachedStore returns a cache of up to maxEntries reports in front of s.
+func NewCachedStore(s Store, maxEntries int, ttl, notFoundTTL time.Duration, opts ...CacheOption) (*CachedStore, error) {
+	c, err := newCachedStore(realClock{}, s, maxEntries, ttl, notFoundTTL)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// NewOfflineStore returns a store answering queries solely from a cache of up to maxEntries
+// reports, regardless of their TTL, e.g: loaded with Load. Queries that are not cached fail with
+// ErrNotCached.
+func NewOfflineStore(maxEntries int, opts ...CacheOption) (*CachedStore, error) {
+	c, err := NewCachedStore(nil, maxEntries, time.Hour, 0, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.offline = true
+	return c, nil
+}
+
+func newCachedStore(c clock, s Store, maxEntries int, ttl, notFoundTTL time.Duration) (*CachedStore, error) {
+	if maxEntries <= 0 {
+		return nil, fmt.Errorf("got invalid cache size %d, want a positive number", maxEntries)
+	}
+	if ttl <= 0 || notFoundTTL < 0 {
+		return nil, fmt.Errorf("got invalid cache TTLs %s and %s, want positive durations", ttl, notFoundTTL)
+	}
+	return &CachedStore{
+		store:       s,
+		clock:       c,
+		maxEntries:  maxEntries,
+		ttl:         ttl,
+		notFoundTTL: notFoundTTL,
+		entries:     list.New(),
+		index:       make(map[string]*list.Element),
+	}, nil
+}
+
+// GetWeatherByAirportCode returns the weather report for the given airports, only querying the
+// decorated store for airports that are not cached.
+func (c *CachedStore) GetWeatherByAirportCode(ctx context.Context, airports []Airport) map[string]WeatherReport {
+	var missing []Airport
+	data := c.lookup(airportQuery, airportCodes(airports), func(i int) {
+		missing = append(missing, airports[i])
+	})
+	if len(missing) == 0 {
+		return data
+	}
+	if c.offline {
+		return c.notCached(data, airportCodes(missing))
+	}
+	fetched := c.store.GetWeatherByAirportCode(ctx, missing)
+	c.add(airportQuery, fetched)
+	for k, r := range fetched {
+		r.Provenance.Cache = CacheMiss
+		data[k] = r
+	}
+	return data
+}
+
+// GetWeatherByCityName returns the weather report for each city name, only querying the decorated
+// store for cities that are not cached.
+func (c *CachedStore) GetWeatherByCityName(ctx context.Context, cities []string) map[string]WeatherReport {
+	var missing []string
+	data := c.lookup(cityQuery, cities, func(i int) {
+		missing = append(missing, cities[i])
+	})
+	if len(missing) == 0 {
+		return data
+	}
+	if c.offline {
+		return c.notCached(data, missing)
+	}
+	fetched := c.store.GetWeatherByCityName(ctx, missing)
+	c.add(cityQuery, fetched)
+	for k, r := range fetched {
+		r.Provenance.Cache = CacheMiss
+		data[k] = r
+	}
+	return data
+}
+
+// notCached adds a failed report for each of the given keys to data.
+func (c *CachedStore) notCached(data map[string]WeatherReport, keys []string) map[string]WeatherReport {
+	for _, k := range keys {
+		data[k] = WeatherReport{Failed: true, NotCached: true, FailMessage: ErrNotCached.Error(), Provenance: Provenance{Cache: CacheMiss}}
+	}
+	return data
+}
+
+// GetAPIUsage returns the decorated store API usage statistics along with the cache statistics.
+func (c *CachedStore) GetAPIUsage() APIUsage {
+	var usage APIUsage
+	if c.store != nil {
+		usage = c.store.GetAPIUsage()
+	}
+	stats := c.Stats()
+	usage.Cache = &stats
+	return usage
+}
+
+// Stats returns a snapshot of the cache statistics.
+func (c *CachedStore) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.entries.Len()
+	return stats
+}
+
+// PlanWeatherByAirportCode returns the plan for answering GetWeatherByAirportCode, cached airports
+// are answered without calling the API.
+func (c *CachedStore) PlanWeatherByAirportCode(airports []Airport) Plan {
+	var missing []Airport
+	cached := c.peek(airportQuery, airportCodes(airports), func(i int) {
+		missing = append(missing, airports[i])
+	})
+	var p Plan
+	if planner, ok := c.store.(Planner); ok {
+		p = planner.PlanWeatherByAirportCode(missing)
+	}
+	return c.plan(p, airportCodes(airports), cached)
+}
+
+// PlanWeatherByCityName returns the plan for answering GetWeatherByCityName, cached cities are
+// answered without calling the API.
+func (c *CachedStore) PlanWeatherByCityName(cities []string) Plan {
+	var missing []string
+	cached := c.peek(cityQuery, cities, func(i int) {
+		missing = append(missing, cities[i])
+	})
+	var p Plan
+	if planner, ok := c.store.(Planner); ok {
+		p = planner.PlanWeatherByCityName(missing)
+	}
+	return c.plan(p, cities, cached)
+}
+
+// plan completes the plan of the queries missing from the cache with the cached ones.
+func (c *CachedStore) plan(p Plan, keys []string, cached int) Plan {
+	unique := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		unique[k] = true
+	}
+	p.Queries = len(keys)
+	p.Unique = len(unique)
+	p.Cached += cached
+	return p
+}
+
+// lookup returns the cached reports of the given query keys and calls miss with the index of the
+// first occurrence of each key that is not cached.
+func (c *CachedStore) lookup(kind queryKind, keys []string, miss func(int)) map[string]WeatherReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data := make(map[string]WeatherReport)
+	seen := make(map[string]bool, len(keys))
+	for i, k := range keys {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		e, ok := c.get(kind, k)
+		if !ok {
+			c.stats.Misses++
+			miss(i)
+			continue
+		}
+		c.stats.Hits++
+		c.entries.MoveToFront(e)
+		entry := e.Value.(*cacheEntry)
+		r := entry.Report
+		now := c.clock.Now()
+		r.Age = now.Sub(entry.FetchedAt)
+		if c.staleAfter > 0 {
+			r.Stale = isStale(r, now, c.staleAfter)
+		}
+		r.Provenance.Cache = CacheHit
+		data[k] = r
+	}
+	return data
+}
+
+// peek is like lookup but leaves statistics and recency untouched. It returns the number of
+// distinct cached keys.
+func (c *CachedStore) peek(kind queryKind, keys []string, miss func(int)) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := make(map[string]bool, len(keys))
+	cached := 0
+	for i, k := range keys {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if _, ok := c.get(kind, k); ok {
+			cached++
+			continue
+		}
+		miss(i)
+	}
+	return cached
+}
+
+// get returns the element of the entry of the given query, unless it expired while online or was
+// fetched in another language, e.g: loaded from a file saved by a run using another one. c.mu must
+// be held.
+func (c *CachedStore) get(kind queryKind, key string) (*list.Element, bool) {
+	e, ok := c.index[c.entryKey(kind, key)]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if !c.offline && !c.clock.Now().Before(entry.ExpiresAt) {
+		return nil, false
+	}
+	// Entries are keyed by language, except the ones saved before they were. Failures aren't localized.
+	if !entry.Report.Failed && entry.Report.Language != c.language {
+		return nil, false
+	}
+	return e, true
+}
+
+// entryKey returns the key of the entry of the given query in the cache language, so reports in
+// different languages are cached side by side.
+func (c *CachedStore) entryKey(kind queryKind, key string) string {
+	if c.language == "" {
+		return queryID(kind, key)
+	}
+	return queryID(kind, key) + "@" + c.language
+}
+
+// add caches the reports that can be cached, evicting the least recently used entries if needed.
+func (c *CachedStore) add(kind queryKind, reports map[string]WeatherReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	for k, r := range reports {
+		fetchedAt := r.Provenance.FetchedAt
+		if fetchedAt.IsZero() {
+			fetchedAt = now
+		}
+		var ttl time.Duration
+		switch {
+		case !r.Failed:
+			ttl = c.ttl
+		case r.NotFound:
+			ttl = c.notFoundTTL
+		}
+		if ttl == 0 {
+			continue
+		}
+		c.push(&cacheEntry{Key: c.entryKey(kind, k), Report: r, FetchedAt: fetchedAt, ExpiresAt: now.Add(ttl)})
+	}
+}
+
+// push adds entry e as the most recently used one, replacing any entry with the same key and
+// evicting the least recently used ones if needed. c.mu must be held.
+func (c *CachedStore) push(e *cacheEntry) {
+	if old, ok := c.index[e.Key]; ok {
+		c.remove(old)
+	}
+	c.index[e.Key] = c.entries.PushFront(e)
+	for c.entries.Len() > c.maxEntries {
+		c.remove(c.entries.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove deletes the entry of element e. c.mu must be held.
+func (c *CachedStore) remove(e *list.Element) {
+	c.entries.Remove(e)
+	delete(c.index, e.Value.(*cacheEntry).Key)
+}
+
+// Load adds the entries saved at path by Save to the cache. A missing file is not an error.
+func (c *CachedStore) Load(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed reading cache file: %v", err)
+	}
+	var entries []*cacheEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return fmt.Errorf("failed parsing cache file %s: %v", path, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	evictions := c.stats.Evictions
+	// Entries are saved least recently used first.
+	for _, e := range entries {
+		c.push(e)
+	}
+	c.stats.Evictions = evictions
+	return nil
+}
+
+// Save persists every cache entry, expired ones included, at path replacing the file atomically.
+func (c *CachedStore) Save(path string) error {
+	c.mu.Lock()
+	entries := make([]*cacheEntry, 0, c.entries.Len())
+	for e := c.entries.Back(); e != nil; e = e.Prev() {
+		entries = append(entries, e.Value.(*cacheEntry))
+	}
+	content, err := json.Marshal(entries)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed saving cache file: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("failed saving cache file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed saving cache file: %v", err)
+	}
+	return nil
+}
+
+// airportCodes returns the code of each airport.
+func airportCodes(airports []Airport) []string {
+	codes := make([]string, len(airports))
+	for i, a := range airports {
+		codes[i] = a.Code
+	}
+	return codes
+}
@@ -0,0 +1,397 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+// fakeStore is a Store that records the queries it receives. Queries for locations in notFound
+// and failing fail, every other query succeeds.
+type fakeStore struct {
+	notFound map[string]bool
+	failing  map[string]bool
+	// fetched holds the keys received by every call, sorted.
+	fetched [][]string
+}
+
+func (s *fakeStore) GetWeatherByAirportCode(ctx context.Context, airports []Airport) map[string]WeatherReport {
+	return s.fetch(airportCodes(airports))
+}
+
+func (s *fakeStore) GetWeatherByCityName(ctx context.Context, cities []string) map[string]WeatherReport {
+	return s.fetch(cities)
+}
+
+func (s *fakeStore) fetch(keys []string) map[string]WeatherReport {
+	data := make(map[string]WeatherReport)
+	for _, k := range keys {
+		switch {
+		case s.notFound[k]:
+			data[k] = WeatherReport{Failed: true, NotFound: true, FailMessage: "not found"}
+		case s.failing[k]:
+			data[k] = WeatherReport{Failed: true, FailMessage: "server error"}
+		default:
+			data[k] = WeatherReport{CityName: k}
+		}
+	}
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	s.fetched = append(s.fetched, sorted)
+	return data
+}
+
+func (s *fakeStore) GetAPIUsage() APIUsage {
+	return APIUsage{SuccessfulCalls: 7}
+}
+
+func TestNewCachedStore(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxEntries  int
+		ttl         time.Duration
+		notFoundTTL time.Duration
+		wantErr     bool
+	}{
+		{name: "valid", maxEntries: 10, ttl: time.Minute, notFoundTTL: time.Second},
+		{name: "negative caching disabled", maxEntries: 10, ttl: time.Minute},
+		{name: "no entries", ttl: time.Minute, wantErr: true},
+		{name: "no TTL", maxEntries: 10, wantErr: true},
+		{name: "negative not found TTL", maxEntries: 10, ttl: time.Minute, notFoundTTL: -time.Second, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewCachedStore(&fakeStore{}, test.maxEntries, test.ttl, test.notFoundTTL)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("NewCachedStore(%d, %s, %s) returned error %v, want error %t", test.maxEntries, test.ttl, test.notFoundTTL, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCachedStore(t *testing.T) {
+	type step struct {
+		// advance is how long to wait before querying.
+		advance time.Duration
+		cities  []string
+		// wantFetched are the cities the decorated store is queried for, nil if it isn't queried.
+		wantFetched []string
+	}
+	tests := []struct {
+		name       string
+		maxEntries int
+		steps      []step
+		wantStats  CacheStats
+	}{
+		{
+			name:       "repeated queries are answered from cache",
+			maxEntries: 10,
+			steps: []step{
+				{cities: []string{"Toluca", "Monterrey", "Toluca"}, wantFetched: []string{"Monterrey", "Toluca"}},
+				{cities: []string{"Toluca", "Monterrey"}},
+				{cities: []string{"Tampico", "Monterrey"}, wantFetched: []string{"Tampico"}},
+			},
+			wantStats: CacheStats{Hits: 3, Misses: 3, Entries: 3},
+		},
+		{
+			name:       "expired entries are fetched again",
+			maxEntries: 10,
+			steps: []step{
+				{cities: []string{"Toluca"}, wantFetched: []string{"Toluca"}},
+				{advance: 59 * time.Second, cities: []string{"Toluca"}},
+				{advance: time.Second, cities: []string{"Toluca"}, wantFetched: []string{"Toluca"}},
+			},
+			wantStats: CacheStats{Hits: 1, Misses: 2, Entries: 1},
+		},
+		{
+			name:       "not found reports are cached for a shorter time",
+			maxEntries: 10,
+			steps: []step{
+				{cities: []string{"Atlantis", "Toluca"}, wantFetched: []string{"Atlantis", "Toluca"}},
+				{advance: 9 * time.Second, cities: []string{"Atlantis", "Toluca"}},
+				{advance: time.Second, cities: []string{"Atlantis", "Toluca"}, wantFetched: []string{"Atlantis"}},
+			},
+			wantStats: CacheStats{Hits: 3, Misses: 3, Entries: 2},
+		},
+		{
+			name:       "other failures are not cached",
+			maxEntries: 10,
+			steps: []step{
+				{cities: []string{"Gotham", "Toluca"}, wantFetched: []string{"Gotham", "Toluca"}},
+				{cities: []string{"Gotham", "Toluca"}, wantFetched: []string{"Gotham"}},
+			},
+			wantStats: CacheStats{Hits: 1, Misses: 3, Entries: 1},
+		},
+		{
+			name:       "least recently used entries are evicted",
+			maxEntries: 2,
+			steps: []step{
+				{cities: []string{"Toluca", "Monterrey"}, wantFetched: []string{"Monterrey", "Toluca"}},
+				{cities: []string{"Toluca"}},
+				{cities: []string{"Tampico"}, wantFetched: []string{"Tampico"}},
+				{cities: []string{"Toluca", "Monterrey"}, wantFetched: []string{"Monterrey"}},
+			},
+			wantStats: CacheStats{Hits: 2, Misses: 4, Evictions: 2, Entries: 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClock()
+			fake := &fakeStore{notFound: map[string]bool{"Atlantis": true}, failing: map[string]bool{"Gotham": true}}
+			s, err := newCachedStore(c, fake, test.maxEntries, time.Minute, 10*time.Second)
+			if err != nil {
+				t.Fatalf("newCachedStore() returned unexpected error: %v", err)
+			}
+			for i, step := range test.steps {
+				c.Advance(step.advance)
+				fake.fetched = nil
+				got := s.GetWeatherByCityName(context.Background(), step.cities)
+				for _, city := range step.cities {
+					if _, ok := got[city]; !ok {
+						t.Errorf("step %d: GetWeatherByCityName(%v) is missing %q", i, step.cities, city)
+					}
+				}
+				var wantFetched [][]string
+				if step.wantFetched != nil {
+					wantFetched = [][]string{step.wantFetched}
+				}
+				if diff := cmp.Diff(fake.fetched, wantFetched); diff != "" {
+					t.Errorf("step %d: got fetched %v, want %v\ndiff: got->want %s", i, fake.fetched, wantFetched, diff)
+				}
+			}
+			if diff := cmp.Diff(s.Stats(), test.wantStats); diff != "" {
+				t.Errorf("Stats(): %v, want %v\ndiff: got->want %s", s.Stats(), test.wantStats, diff)
+			}
+		})
+	}
+}
+
+func TestCachedStore_queryKinds(t *testing.T) {
+	fake := &fakeStore{}
+	s, _ := NewCachedStore(fake, 10, time.Minute, time.Minute)
+	s.GetWeatherByAirportCode(context.Background(), []Airport{{Code: "MEX"}})
+	s.GetWeatherByCityName(context.Background(), []string{"MEX"})
+	s.GetWeatherByAirportCode(context.Background(), []Airport{{Code: "MEX"}})
+
+	want := [][]string{{"MEX"}, {"MEX"}}
+	if diff := cmp.Diff(fake.fetched, want); diff != "" {
+		t.Errorf("got fetched %v, want %v\ndiff: got->want %s", fake.fetched, want, diff)
+	}
+}
+
+func TestCachedStore_GetAPIUsage(t *testing.T) {
+	s, _ := NewCachedStore(&fakeStore{}, 10, time.Minute, time.Minute)
+	s.GetWeatherByCityName(context.Background(), []string{"Toluca", "Toluca"})
+	s.GetWeatherByCityName(context.Background(), []string{"Toluca"})
+
+	want := APIUsage{SuccessfulCalls: 7, Cache: &CacheStats{Hits: 1, Misses: 1, Entries: 1}}
+	if diff := cmp.Diff(s.GetAPIUsage(), want); diff != "" {
+		t.Errorf("GetAPIUsage(): %v, want %v\ndiff: got->want %s", s.GetAPIUsage(), want, diff)
+	}
+}
+
+func TestCachedStore_Plan(t *testing.T) {
+	api := openweather.NewAPIMockClient(fixedWeatherResponse)
+	s, _ := NewCachedStore(NewConcurrentStore(api, WithRequestsPerMinute(2)), 10, time.Minute, time.Minute, WithCacheLanguage(fixedWeatherResponse.Language))
+	s.GetWeatherByAirportCode(context.Background(), []Airport{{Code: "MEX"}, {Code: "TLC"}})
+
+	got := s.PlanWeatherByAirportCode([]Airport{{Code: "MEX"}, {Code: "MTY"}, {Code: "TAM"}, {Code: "MTY"}, {Code: "CUN"}})
+	want := Plan{Queries: 5, Unique: 4, Cached: 1, Calls: 3, Batches: 2, RequestsPerMinute: 2}
+	if diff := cmp.Diff(got, want, cmpopts.IgnoreFields(Plan{}, "EstimatedDuration")); diff != "" {
+		t.Errorf("PlanWeatherByAirportCode(): %v, want %v\ndiff: got->want %s", got, want, diff)
+	}
+	// Planning leaves the cache untouched.
+	if diff := cmp.Diff(s.Stats(), CacheStats{Misses: 2, Entries: 2}); diff != "" {
+		t.Errorf("Stats() after planning: %v\ndiff: got->want %s", s.Stats(), diff)
+	}
+}
+
+func TestCachedStore_offline(t *testing.T) {
+	c := newFakeClock()
+	fake := &fakeStore{notFound: map[string]bool{"Atlantis": true}}
+	online, _ := newCachedStore(c, fake, 10, time.Minute, time.Second)
+	online.GetWeatherByCityName(context.Background(), []string{"Toluca", "Monterrey", "Atlantis"})
+	c.Advance(30 * time.Second)
+	online.GetWeatherByCityName(context.Background(), []string{"Tampico", "Toluca"})
+	path := filepath.Join(tempDir(t), "weatherreport", "cache.json")
+	if err := online.Save(path); err != nil {
+		t.Fatalf("Save(%q) returned unexpected error: %v", path, err)
+	}
+
+	// Entries are answered offline well past their TTL.
+	c.Advance(time.Hour)
+	offline, _ := newCachedStore(c, nil, 10, time.Minute, 0)
+	offline.offline = true
+	if err := offline.Load(path); err != nil {
+		t.Fatalf("Load(%q) returned unexpected error: %v", path, err)
+	}
+	got := offline.GetWeatherByCityName(context.Background(), []string{"Toluca", "Monterrey", "Tampico", "Atlantis", "Puebla"})
+	want := map[string]WeatherReport{
+		"Toluca":    {CityName: "Toluca", Age: time.Hour + 30*time.Second, Provenance: Provenance{Cache: CacheHit}},
+		"Monterrey": {CityName: "Monterrey", Age: time.Hour + 30*time.Second, Provenance: Provenance{Cache: CacheHit}},
+		"Tampico":   {CityName: "Tampico", Age: time.Hour, Provenance: Provenance{Cache: CacheHit}},
+		"Atlantis":  {Failed: true, NotFound: true, FailMessage: "not found", Age: time.Hour + 30*time.Second, Provenance: Provenance{Cache: CacheHit}},
+		"Puebla":    {Failed: true, NotCached: true, FailMessage: ErrNotCached.Error(), Provenance: Provenance{Cache: CacheMiss}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("GetWeatherByCityName(): %v, want %v\ndiff: got->want %s", got, want, diff)
+	}
+	wantStats := CacheStats{Hits: 4, Misses: 1, Entries: 4}
+	if diff := cmp.Diff(offline.GetAPIUsage(), APIUsage{Cache: &wantStats}); diff != "" {
+		t.Errorf("GetAPIUsage(): %v\ndiff: got->want %s", offline.GetAPIUsage(), diff)
+	}
+}
+
+// observingStore is a Store answering every query with an observation made right now.
+type observingStore struct {
+	fakeStore
+	clock clock
+}
+
+func (s *observingStore) GetWeatherByCityName(ctx context.Context, cities []string) map[string]WeatherReport {
+	data := s.fetch(cities)
+	for k, r := range data {
+		r.ObservationTime = s.clock.Now()
+		data[k] = r
+	}
+	return data
+}
+
+func TestCachedStore_stale(t *testing.T) {
+	c := newFakeClock()
+	s, _ := newCachedStore(c, &observingStore{clock: c}, 10, 24*time.Hour, 0)
+	WithCacheStaleAfter(time.Hour)(s)
+	s.GetWeatherByCityName(context.Background(), []string{"Toluca"})
+
+	for _, test := range []struct {
+		after time.Duration
+		want  bool
+	}{
+		{after: 30 * time.Minute, want: false},
+		{after: 2 * time.Hour, want: true},
+	} {
+		c.Advance(test.after)
+		got := s.GetWeatherByCityName(context.Background(), []string{"Toluca"})["Toluca"]
+		if got.Provenance.Cache != CacheHit || got.Stale != test.want {
+			t.Errorf("got %s report stale %t %s after fetching it, want a cache hit stale %t", got.Provenance.Cache, got.Stale, got.Age, test.want)
+		}
+	}
+}
+
+// languageStore is a Store answering every query in the given language.
+type languageStore struct {
+	fakeStore
+	language string
+}
+
+func (s *languageStore) GetWeatherByCityName(ctx context.Context, cities []string) map[string]WeatherReport {
+	data := s.fetch(cities)
+	for k, r := range data {
+		if !r.Failed {
+			r.Language = s.language
+			data[k] = r
+		}
+	}
+	return data
+}
+
+func TestCachedStore_language(t *testing.T) {
+	s, _ := NewCachedStore(&languageStore{}, 10, time.Minute, time.Minute)
+	s.GetWeatherByCityName(context.Background(), []string{"Toluca"})
+	path := filepath.Join(tempDir(t), "cache.json")
+	if err := s.Save(path); err != nil {
+		t.Fatalf("Save(%q) returned unexpected error: %v", path, err)
+	}
+
+	// A run in another language fetches reports again.
+	spanish := &languageStore{language: "es"}
+	es, _ := NewCachedStore(spanish, 10, time.Minute, time.Minute, WithCacheLanguage("es"))
+	if err := es.Load(path); err != nil {
+		t.Fatalf("Load(%q) returned unexpected error: %v", path, err)
+	}
+	got := es.GetWeatherByCityName(context.Background(), []string{"Toluca"})
+	if got["Toluca"].Language != "es" {
+		t.Errorf("GetWeatherByCityName(Toluca) in es returned a report in %q, want es", got["Toluca"].Language)
+	}
+	if diff := cmp.Diff(spanish.fetched, [][]string{{"Toluca"}}); diff != "" {
+		t.Errorf("got fetched %v, want [[Toluca]]\ndiff: got->want %s", spanish.fetched, diff)
+	}
+	if err := es.Save(path); err != nil {
+		t.Fatalf("Save(%q) returned unexpected error: %v", path, err)
+	}
+
+	// Both languages are cached side by side, offline runs only answer with their own.
+	for _, lang := range []string{"", "es"} {
+		offline, _ := NewOfflineStore(10, WithCacheLanguage(lang))
+		if err := offline.Load(path); err != nil {
+			t.Fatalf("Load(%q) returned unexpected error: %v", path, err)
+		}
+		got := offline.GetWeatherByCityName(context.Background(), []string{"Toluca"})["Toluca"]
+		if got.Failed || got.Language != lang {
+			t.Errorf("offline GetWeatherByCityName(Toluca) in %q returned %+v, want a report in %q", lang, got, lang)
+		}
+	}
+
+	// Entries saved without a language in their key are checked too.
+	legacy, _ := NewOfflineStore(10)
+	legacy.push(&cacheEntry{Key: queryID(cityQuery, "Puebla"), Report: WeatherReport{CityName: "Puebla", Language: "es"}})
+	if got := legacy.GetWeatherByCityName(context.Background(), []string{"Puebla"})["Puebla"]; !got.NotCached {
+		t.Errorf("offline GetWeatherByCityName(Puebla) cached in es returned %+v, want not cached", got)
+	}
+}
+
+func TestCachedStore_LoadKeepsRecency(t *testing.T) {
+	fake := &fakeStore{}
+	s, _ := NewCachedStore(fake, 10, time.Minute, time.Minute)
+	s.GetWeatherByCityName(context.Background(), []string{"Toluca", "Monterrey"})
+	s.GetWeatherByCityName(context.Background(), []string{"Tampico"})
+	s.GetWeatherByCityName(context.Background(), []string{"Toluca"})
+	path := filepath.Join(tempDir(t), "cache.json")
+	if err := s.Save(path); err != nil {
+		t.Fatalf("Save(%q) returned unexpected error: %v", path, err)
+	}
+
+	// Only the 2 most recently used entries fit.
+	loaded, _ := NewCachedStore(fake, 2, time.Minute, time.Minute)
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load(%q) returned unexpected error: %v", path, err)
+	}
+	fake.fetched = nil
+	loaded.GetWeatherByCityName(context.Background(), []string{"Toluca", "Monterrey", "Tampico"})
+	want := [][]string{{"Monterrey"}}
+	if diff := cmp.Diff(fake.fetched, want); diff != "" {
+		t.Errorf("got fetched %v, want %v\ndiff: got->want %s", fake.fetched, want, diff)
+	}
+}
+
+func TestCachedStore_Load(t *testing.T) {
+	dir := tempDir(t)
+	malformed := filepath.Join(dir, "malformed.json")
+	if err := ioutil.WriteFile(malformed, []byte(`[{"key": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "missing file", path: filepath.Join(dir, "missing.json")},
+		{name: "malformed file", path: malformed, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, _ := NewOfflineStore(10)
+			err := s.Load(test.path)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("Load(%q) returned error %v, want error %t", test.path, err, test.wantErr)
+			}
+		})
+	}
+}
@@ -1,7 +1,11 @@
 package store
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/pablotrinidad/weatherreport/store/openweather"
@@ -9,20 +13,132 @@ import (
 
 const maxConcurrentRequestsPerMinute = 60
 
-// ConcurrentStore is a concurrent Store implementation.
+// DefaultConcurrency is the default maximum number of API calls ConcurrentStore performs at the
+// same time. HTTP clients used by the store should keep at least this many idle connections per host.
+const DefaultConcurrency = 10
+
+const defaultGracePeriod = 10 * time.Second
+
+// ConcurrentStore is a concurrent Store implementation. It is safe for concurrent use, limits on
+// API calls apply to all callers as a whole.
 type ConcurrentStore struct {
 	// ow is an Open Weather API client.
-	ow    openweather.API
+	ow openweather.API
+	// mu guards usage, flights and fetches.
+	mu    sync.Mutex
 	usage APIUsage
+	// flights holds the API calls in progress by query, see join.
+	flights map[string]*flight
+	// fetches is the number of batches of requests performed so far, it numbers flight leaders.
+	fetches int
+	// requestsPerMinute is the maximum number of API calls started within any one minute window.
+	requestsPerMinute int
+	// rate adapts the number of API calls started per minute, up to requestsPerMinute, to the
+	// rate-limited and server errors returned by the API.
+	rate *aimdController
+	// limiter throttles API calls to the current rate.
+	limiter *rateLimiter
+	// slots holds a token for each API call in flight, up to concurrency.
+	slots chan struct{}
+	// concurrency is the maximum number of API calls in flight at the same time.
+	concurrency int
+	// journal records completed queries and provides the ones completed by previous runs, if set.
+	journal *Journal
+	// gracePeriod is how long in-flight calls are waited for once the store is interrupted.
+	gracePeriod time.Duration
+	// staleAfter is the age past which observations are flagged as stale, zero disables the check.
+	staleAfter time.Duration
+	// staleRefetches is the number of times stale reports are fetched again.
+	staleRefetches int
+	clock          clock
+}
+
+// Option configures optional ConcurrentStore settings.
+type Option func(*ConcurrentStore)
+
+// WithRequestsPerMinute sets the maximum number of API calls started within any one minute window,
+// e.g: the aggregated limit of a KeyPool. Defaults to 60, OpenWeather's free plan limit.
+func WithRequestsPerMinute(n int) Option {
+	return func(s *ConcurrentStore) {
+		if n > 0 {
+			s.requestsPerMinute = n
+		}
+	}
+}
+
+// WithConcurrency sets the maximum number of API calls in flight at the same time, independently
+// of how many are performed per minute. Defaults to DefaultConcurrency.
+func WithConcurrency(n int) Option {
+	return func(s *ConcurrentStore) {
+		if n > 0 {
+			s.concurrency = n
+		}
+	}
+}
+
+// WithJournal records each completed query in j as soon as it finishes. Queries successfully
+// completed by previous runs recorded in j are not fetched again, their journaled reports are
+// returned instead.
+func WithJournal(j *Journal) Option {
+	return func(s *ConcurrentStore) {
+		s.journal = j
+	}
+}
+
+// withClock makes the store use clock c, e.g: a fake clock in tests.
+func withClock(c clock) Option {
+	return func(s *ConcurrentStore) {
+		s.clock = c
+	}
+}
+
+// WithGracePeriod sets how long in-flight calls are waited for once the context passed to the store
+// is cancelled. Defaults to 10 seconds.
+func WithGracePeriod(d time.Duration) Option {
+	return func(s *ConcurrentStore) {
+		s.gracePeriod = d
+	}
+}
+
+// WithStaleAfter flags reports whose observation is older than d when fetched, or resumed from the
+// journal, as stale.
+// Observations are never flagged by default.
+func WithStaleAfter(d time.Duration) Option {
+	return func(s *ConcurrentStore) {
+		s.staleAfter = d
+	}
+}
+
+// WithStaleRefetch fetches stale reports again up to n times, keeping the most recent observation.
+// Requires WithStaleAfter. Stale reports are not fetched again by default.
+func WithStaleRefetch(n int) Option {
+	return func(s *ConcurrentStore) {
+		s.staleRefetches = n
+	}
 }
 
-func NewConcurrentStore(ow openweather.API) Store {
-	return &ConcurrentStore{ow: ow, usage: APIUsage{}}
+func NewConcurrentStore(ow openweather.API, opts ...Option) Store {
+	s := &ConcurrentStore{
+		ow:                ow,
+		usage:             APIUsage{},
+		flights:           make(map[string]*flight),
+		requestsPerMinute: maxConcurrentRequestsPerMinute,
+		concurrency:       DefaultConcurrency,
+		gracePeriod:       defaultGracePeriod,
+		clock:             realClock{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.rate = newAIMDController(s.clock, s.requestsPerMinute)
+	s.limiter = newRateLimiter(s.clock, s.rate.limit(), time.Minute)
+	s.slots = make(chan struct{}, s.concurrency)
+	return s
 }
 
 // GetWeatherByAirportCode returns the weather report for the given airports on the current date and time.
 // The returned map contains the airport code as the key and a weather report instance as value.
-func (s *ConcurrentStore) GetWeatherByAirportCode(airports []Airport) map[string]WeatherReport {
+func (s *ConcurrentStore) GetWeatherByAirportCode(ctx context.Context, airports []Airport) map[string]WeatherReport {
 	requests := make(map[string]func() (*openweather.WeatherItem, error))
 	for i := range airports {
 		a := airports[i]
@@ -32,13 +148,12 @@ func (s *ConcurrentStore) GetWeatherByAirportCode(airports []Airport) map[string
 			return s.ow.GetWeatherByCoords(a.Latitude, a.Longitude)
 		}
 	}
-	results := s.fetchConcurrently(requests)
-	return s.parseResults(results)
+	return s.fetch(ctx, airportQuery, requests)
 }
 
 // GetWeatherByCityName returns the weather report for each city name. The returned map contains
 // the city name as key and a weather report instance as value.
-func (s *ConcurrentStore) GetWeatherByCityName(cities []string) map[string]WeatherReport {
+func (s *ConcurrentStore) GetWeatherByCityName(ctx context.Context, cities []string) map[string]WeatherReport {
 	requests := make(map[string]func() (*openweather.WeatherItem, error))
 	for i := range cities {
 		cityName := cities[i]
@@ -48,91 +163,395 @@ func (s *ConcurrentStore) GetWeatherByCityName(cities []string) map[string]Weath
 			return s.ow.GetWeatherByCityName(cityName)
 		}
 	}
-	results := s.fetchConcurrently(requests)
-	return s.parseResults(results)
+	return s.fetch(ctx, cityQuery, requests)
+}
+
+// fetch returns the reports of the given requests, skipping the ones completed by previous runs
+// according to the journal.
+func (s *ConcurrentStore) fetch(ctx context.Context, kind queryKind, requests map[string]func() (*openweather.WeatherItem, error)) map[string]WeatherReport {
+	resumed := s.skipCompleted(requests)
+	if len(resumed) > 0 {
+		log.Printf("\t\t...resuming %d queries completed by previous runs", len(resumed))
+	}
+	data := s.parseResults(s.fetchConcurrently(ctx, kind, requests))
+	s.refetchStale(ctx, kind, requests, data)
+	now := s.clock.Now()
+	for key, r := range resumed {
+		// Observations keep aging after being journaled.
+		r.Stale = isStale(r, now, s.staleAfter)
+		data[key] = r
+	}
+	return data
+}
+
+// skipCompleted removes the requests completed by previous runs according to the journal and
+// returns their journaled reports.
+func (s *ConcurrentStore) skipCompleted(requests map[string]func() (*openweather.WeatherItem, error)) map[string]WeatherReport {
+	resumed := make(map[string]WeatherReport)
+	if s.journal == nil {
+		return resumed
+	}
+	for key, r := range s.journal.Completed() {
+		if _, ok := requests[key]; ok {
+			resumed[key] = r
+			delete(requests, key)
+		}
+	}
+	return resumed
 }
 
 func (s *ConcurrentStore) parseResults(results map[string]*requestResult) map[string]WeatherReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	data := make(map[string]WeatherReport)
+	now := s.clock.Now()
 	for key, val := range results {
+		r := val.report()
+		r.Stale = isStale(r, now, s.staleAfter)
+		data[key] = r
+		if val.notAttempted {
+			continue
+		}
+		if val.shared {
+			// The call was performed, and accounted for, by another caller.
+			s.usage.CoalescedRequests++
+			continue
+		}
+		if errors.Is(val.err, ErrCircuitOpen) {
+			// The call never reached the API.
+			s.usage.ShortCircuited++
+			continue
+		}
 		if val.err != nil {
-			data[key] = WeatherReport{
-				Failed:      true,
-				FailMessage: val.err.Error(),
-			}
 			s.usage.FailedCalls++
 			continue
 		}
-		data[key] = WeatherReport{
-			Lat:             val.data.Lat,
-			Lon:             val.data.Lon,
-			Description:     val.data.Description,
-			CityName:        val.data.CityName,
-			Temp:            val.data.Temp,
-			MaxTemp:         val.data.MaxTemp,
-			MinTemp:         val.data.MinTemp,
-			FeelsLike:       val.data.FeelsLike,
-			Humidity:        val.data.Humidity,
-			ObservationTime: time.Unix(int64(val.data.ObservationTime), 0),
-			Failed:          false,
-		}
 		s.usage.SuccessfulCalls++
 	}
 	return data
 }
 
+// refetchStale performs the requests of stale reports in data again, up to s.staleRefetches times,
+// replacing them with the refetched reports that have more recent observations.
+func (s *ConcurrentStore) refetchStale(ctx context.Context, kind queryKind, requests map[string]func() (*openweather.WeatherItem, error), data map[string]WeatherReport) {
+	for i := 0; i < s.staleRefetches && ctx.Err() == nil; i++ {
+		stale := make(map[string]func() (*openweather.WeatherItem, error))
+		for key, r := range data {
+			if r.Stale {
+				stale[key] = requests[key]
+			}
+		}
+		if len(stale) == 0 {
+			return
+		}
+		log.Printf("\t\t...fetching %d stale observations again", len(stale))
+		for key, r := range s.parseResults(s.fetchConcurrently(ctx, kind, stale)) {
+			if !r.Failed && r.ObservationTime.After(data[key].ObservationTime) {
+				data[key] = r
+			}
+		}
+	}
+}
+
+// errInterrupted is the error of requests abandoned while in flight because the store was interrupted.
+var errInterrupted = errors.New("interrupted while waiting for the API response")
+
 type requestResult struct {
 	data *openweather.WeatherItem
 	key  string
 	err  error
+	// provenance of the result, see call.
+	provenance Provenance
+	// notAttempted is set for requests never performed because the store was interrupted.
+	notAttempted bool
+	// shared is set for results of calls performed for another caller, see join.
+	shared bool
 }
 
-// fetchConcurrently returns the result of performing the given requests concurrently and in batches.
-func (s ConcurrentStore) fetchConcurrently(requests map[string]func() (*openweather.WeatherItem, error)) map[string]*requestResult {
-	cn := make(chan *requestResult, len(requests))
-	fns := make([]func(), len(requests))
-	i := 0
+// report returns the weather report of the request result.
+func (r *requestResult) report() WeatherReport {
+	if r.notAttempted {
+		return WeatherReport{
+			Failed:       true,
+			NotAttempted: true,
+			FailMessage:  "not attempted, run was interrupted",
+		}
+	}
+	if r.err != nil {
+		return WeatherReport{
+			Failed:      true,
+			FailMessage: r.err.Error(),
+			NotFound:    errors.Is(r.err, openweather.ErrNotFound),
+			Provenance:  r.provenance,
+		}
+	}
+	return WeatherReport{
+		Lat:             r.data.Lat,
+		Lon:             r.data.Lon,
+		Description:     r.data.Description,
+		Details:         r.data.Details,
+		Language:        r.data.Language,
+		CityName:        r.data.CityName,
+		Units:           r.data.Units,
+		Temp:            r.data.Temp,
+		MaxTemp:         r.data.MaxTemp,
+		MinTemp:         r.data.MinTemp,
+		FeelsLike:       r.data.FeelsLike,
+		Humidity:        r.data.Humidity,
+		WindSpeed:       r.data.WindSpeed,
+		ObservationTime: time.Unix(int64(r.data.ObservationTime), 0),
+		Failed:          false,
+		Provenance:      r.provenance,
+	}
+}
+
+// fetchConcurrently returns the result of performing the given requests of the given kind with a
+// pool of workers. Up to s.concurrency requests are in flight at the same time and no more than the
+// current rate (see aimdController) of requests are started within any one minute window. Requests
+// already in flight for another caller are not performed again, their result is shared instead.
+// Once ctx is cancelled workers stop starting new requests and in-flight ones are waited for up to
+// the store grace period. Requests that were not completed by then are reported as interrupted or
+// not attempted.
+func (s *ConcurrentStore) fetchConcurrently(ctx context.Context, kind queryKind, requests map[string]func() (*openweather.WeatherItem, error)) map[string]*requestResult {
+	s.mu.Lock()
+	s.fetches++
+	fetch := s.fetches
+	s.mu.Unlock()
+	queue := make(chan string, len(requests))
 	for k := range requests {
-		// Unpacking is required to avoid re-usage of references.
-		f, key := requests[k], k
-		fns[i] = func() {
-			report, err := f()
-			cn <- &requestResult{data: report, err: err, key: key}
+		queue <- k
+	}
+	close(queue)
+
+	var mu sync.Mutex
+	// closed is set once results are returned, late in-flight requests are discarded afterwards.
+	closed := false
+	results := make(map[string]*requestResult, len(requests))
+	started := make(map[string]bool, len(requests))
+	// start marks key as started unless results were already returned.
+	start := func(key string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return false
+		}
+		started[key] = true
+		return true
+	}
+	done := func(key string, res *requestResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		results[key] = res
+		if n := len(results); n%s.requestsPerMinute == 0 && n < len(requests) {
+			log.Printf("\t\t\t⏳ %d done, %d pending (%d calls per minute)", n, len(requests)-n, s.rate.limit())
+		}
+	}
+	// perform completes the request identified by key, either by performing it or by sharing the
+	// result of the same request in flight for another caller. It returns false if the worker
+	// must stop because ctx was cancelled.
+	perform := func(key string) bool {
+		for ctx.Err() == nil {
+			f, leader := s.join(kind, key, fetch)
+			if !leader {
+				if !start(key) {
+					return false
+				}
+				<-f.done
+				if f.res == nil {
+					// The call was abandoned by its caller, try performing it.
+					continue
+				}
+				shared := &requestResult{data: f.res.data, key: key, err: f.res.err, provenance: f.res.provenance, shared: true}
+				shared.provenance.SharedFrom = f.leader
+				done(key, shared)
+				return true
+			}
+			if !s.waitTurn(ctx) {
+				s.land(kind, key, f, nil)
+				return false
+			}
+			if !start(key) {
+				<-s.slots
+				s.land(kind, key, f, nil)
+				return false
+			}
+			res := s.call(key, requests[key])
+			<-s.slots
+			s.land(kind, key, f, res)
+			if s.rate.record(res.err) {
+				log.Printf("\t\t\t⚠️  API overloaded (%v), slowing down to %d calls per minute", res.err, s.rate.limit())
+			}
+			s.limiter.setLimit(s.rate.limit())
+			if errors.Is(res.err, ErrCircuitOpen) {
+				// The call never reached the API, it doesn't count towards the rate limit.
+				s.limiter.unreserve()
+			}
+			done(key, res)
+			return true
+		}
+		return false
+	}
+
+	workers := s.concurrency
+	if workers > len(requests) {
+		workers = len(requests)
+	}
+	if len(requests) > 0 {
+		log.Printf("\t\t...performing %d API calls (up to %d at the same time and %d per minute)", len(requests), workers, s.rate.limit())
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				if !perform(key) {
+					return
+				}
+			}
+		}()
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		select {
+		case <-finished:
+		case <-s.clock.After(s.gracePeriod):
+			log.Printf("\t\t\t⚠️  interrupted, abandoning in-flight calls after waiting %s", s.gracePeriod)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	closed = true
+	out := make(map[string]*requestResult, len(requests))
+	for key := range requests {
+		switch r, ok := results[key]; {
+		case ok:
+			out[key] = r
+		case started[key]:
+			out[key] = &requestResult{key: key, err: errInterrupted}
+		default:
+			out[key] = &requestResult{key: key, notAttempted: true}
 		}
-		i++
 	}
+	return out
+}
+
+// queryKind tells apart queries with the same key, e.g: an airport code and a city name.
+type queryKind string
+
+const (
+	airportQuery queryKind = "airport"
+	cityQuery    queryKind = "city"
+)
+
+// queryID identifies the query of the given kind and key among queries of every kind.
+func queryID(kind queryKind, key string) string {
+	return string(kind) + ":" + key
+}
+
+// flight is an API call in progress whose result is shared by every caller performing the same query.
+type flight struct {
+	done chan struct{}
+	// res is the result of the call, nil if it was abandoned before being performed.
+	res *requestResult
+	// waiters is the number of callers that joined the flight besides the one performing the call.
+	waiters int
+	// leader describes the query of the caller performing the call, e.g: "city Toluca of fetch 3".
+	leader string
+}
+
+// join returns the flight in progress for the query of the given kind and key. If there is none,
+// a new flight led by the given fetch is returned along with true, the caller must perform the call
+// and land the flight.
+func (s *ConcurrentStore) join(kind queryKind, key string, fetch int) (*flight, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := queryID(kind, key)
+	if f, ok := s.flights[id]; ok {
+		f.waiters++
+		return f, false
+	}
+	f := &flight{done: make(chan struct{}), leader: fmt.Sprintf("%s %s of fetch %d", kind, key, fetch)}
+	s.flights[id] = f
+	return f, true
+}
+
+// land completes flight f with res, releasing every caller waiting for it.
+func (s *ConcurrentStore) land(kind queryKind, key string, f *flight, res *requestResult) {
+	s.mu.Lock()
+	delete(s.flights, queryID(kind, key))
+	s.mu.Unlock()
+	f.res = res
+	close(f.done)
+}
 
-	// Concurrently process requests in batched of up to 60 requests per minute
-	start := 0
-	breakNext := false
-	for !breakNext {
-		end := start + maxConcurrentRequestsPerMinute
-		if end > len(requests) {
-			breakNext = true
-			end = len(requests)
+// waitTurn blocks until a call slot is free and the rate limiter allows starting a new request.
+// The caller must release the slot once the request is done. It returns false, holding no slot,
+// if ctx is cancelled first.
+func (s *ConcurrentStore) waitTurn(ctx context.Context) bool {
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	for ctx.Err() == nil {
+		wait := s.limiter.reserve()
+		if wait == 0 {
+			return true
 		}
-		log.Printf("\t\t...performing %d (%d pending) concurrent API calls", end-start, len(requests)-end)
-		callConcurrent(fns[start:end])
-		if end-start == maxConcurrentRequestsPerMinute {
-			log.Printf("\t\t\t⏳ done, waiting a minute to comply with 60 calls/minute constraint")
-			log.Printf("\t\t\tremaining time: %d minutes", (len(requests)-end)/60)
-			time.Sleep(1 * time.Minute)
+		select {
+		case <-s.clock.After(wait):
+		case <-ctx.Done():
 		}
-		start = end
 	}
-	close(cn)
+	<-s.slots
+	return false
+}
 
-	// Read results
-	results := make(map[string]*requestResult, len(requests))
-	for r := range cn {
-		if _, ok := results[r.key]; !ok {
-			results[r.key] = r
+// call performs request f identified by key and records its result in the journal, if any.
+func (s *ConcurrentStore) call(key string, f func() (*openweather.WeatherItem, error)) *requestResult {
+	start := s.clock.Now()
+	report, err := f()
+	end := s.clock.Now()
+	res := &requestResult{data: report, err: err, key: key, provenance: Provenance{
+		Provider:  openweather.ProviderName,
+		FetchedAt: end,
+		Latency:   end.Sub(start),
+		// Calls that failed are assumed to be performed once, clients only report retries on success.
+		Attempts: 1,
+	}}
+	if report != nil {
+		res.provenance.Endpoint = report.Endpoint
+		if report.Attempts > 0 {
+			res.provenance.Attempts = report.Attempts
 		}
 	}
-	return results
+	if s.journal != nil {
+		if err := s.journal.Record(key, res.report()); err != nil {
+			log.Printf("\t\t\t⚠️  failed recording %q in journal: %v", key, err)
+		}
+	}
+	return res
 }
 
-// GetAPIUsage returns OpenWeather API usage statistics.
+// GetAPIUsage returns a snapshot of OpenWeather API usage statistics.
 func (s *ConcurrentStore) GetAPIUsage() APIUsage {
-	return s.usage
+	s.mu.Lock()
+	usage := s.usage
+	s.mu.Unlock()
+	usage.RequestsPerMinute = s.rate.limit()
+	if p, ok := s.ow.(interface{ KeyUsage() []KeyUsage }); ok {
+		usage.Keys = p.KeyUsage()
+	}
+	return usage
 }
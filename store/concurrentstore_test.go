@@ -1,10 +1,15 @@
 package store
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/pablotrinidad/weatherreport/store/openweather"
 )
 
@@ -13,6 +18,8 @@ var fixedWeatherResponse openweather.WeatherItem = openweather.WeatherItem{
 	Lat:             19.4360762,
 	Lon:             -99.074097,
 	Description:     []string{"cloudy", "foggy"},
+	Details:         []string{"nublado", "niebla"},
+	Language:        "es",
 	CityName:        "Mountain View",
 	ObservationTime: 1601438975,
 	Temp:            13,
@@ -20,18 +27,27 @@ var fixedWeatherResponse openweather.WeatherItem = openweather.WeatherItem{
 	MinTemp:         10,
 	FeelsLike:       14,
 	Humidity:        60,
+	WindSpeed:       3.5,
+	Units:           openweather.Metric,
 }
 
+// ignoreProvenance ignores report provenance, which depends on the time calls are performed.
+var ignoreProvenance = cmpopts.IgnoreFields(WeatherReport{}, "Provenance")
+
 var fixedWeatherReport WeatherReport = WeatherReport{
 	Lat:             19.4360762,
 	Lon:             -99.074097,
 	Description:     []string{"cloudy", "foggy"},
+	Details:         []string{"nublado", "niebla"},
+	Language:        "es",
 	CityName:        "Mountain View",
 	Temp:            13,
 	MaxTemp:         15,
 	MinTemp:         10,
 	FeelsLike:       14,
 	Humidity:        60,
+	WindSpeed:       3.5,
+	Units:           openweather.Metric,
 	ObservationTime: time.Unix(1601438975, 0),
 }
 
@@ -56,21 +72,21 @@ func TestConcurrentStore_GetWeatherByAirportCode(t *testing.T) {
 			queries:     []Airport{},
 			wantErrors:  map[string]bool{},
 			wantSuccess: map[string]bool{},
-			wantUsage:   APIUsage{SuccessfulCalls: 0, FailedCalls: 0},
+			wantUsage:   APIUsage{SuccessfulCalls: 0, FailedCalls: 0, RequestsPerMinute: 60},
 		},
 		{
 			name:        "single-element airport list",
 			queries:     []Airport{airports["TLC"]},
 			wantErrors:  map[string]bool{},
 			wantSuccess: map[string]bool{"TLC": true},
-			wantUsage:   APIUsage{SuccessfulCalls: 1, FailedCalls: 0},
+			wantUsage:   APIUsage{SuccessfulCalls: 1, FailedCalls: 0, RequestsPerMinute: 60},
 		},
 		{
 			name:        "multiple unique airports",
 			queries:     []Airport{airports["TLC"], airports["MTY"], airports["MEX"], airports["TAM"]},
 			wantErrors:  map[string]bool{},
 			wantSuccess: map[string]bool{"TLC": true, "MTY": true, "MEX": true, "TAM": true},
-			wantUsage:   APIUsage{SuccessfulCalls: 4, FailedCalls: 0},
+			wantUsage:   APIUsage{SuccessfulCalls: 4, FailedCalls: 0, RequestsPerMinute: 60},
 		},
 		{
 			name: "multiple repeated airports",
@@ -84,13 +100,13 @@ func TestConcurrentStore_GetWeatherByAirportCode(t *testing.T) {
 			},
 			wantErrors:  map[string]bool{},
 			wantSuccess: map[string]bool{"TLC": true, "MTY": true, "MEX": true, "TAM": true},
-			wantUsage:   APIUsage{SuccessfulCalls: 4, FailedCalls: 0},
+			wantUsage:   APIUsage{SuccessfulCalls: 4, FailedCalls: 0, RequestsPerMinute: 60},
 		},
 		{
 			name:        "failed API call",
 			queries:     []Airport{airports["TLC"], airports["MTY"], airports["MEX"], airports["TAM"]},
 			apiMustFail: true,
-			wantUsage:   APIUsage{SuccessfulCalls: 0, FailedCalls: 4},
+			wantUsage:   APIUsage{SuccessfulCalls: 0, FailedCalls: 4, RequestsPerMinute: 60},
 			wantErrors:  map[string]bool{"TLC": true, "MTY": true, "MEX": true, "TAM": true},
 			wantSuccess: map[string]bool{},
 		},
@@ -100,7 +116,7 @@ func TestConcurrentStore_GetWeatherByAirportCode(t *testing.T) {
 			api := openweather.NewAPIMockClient(fixedWeatherResponse)
 			api.FailNext = test.apiMustFail
 			store := NewConcurrentStore(api)
-			gotRes := store.GetWeatherByAirportCode(test.queries)
+			gotRes := store.GetWeatherByAirportCode(context.Background(), test.queries)
 			gotUsage := store.GetAPIUsage()
 			if diff := cmp.Diff(gotUsage, test.wantUsage); diff != "" {
 				t.Errorf("got usage %v, want %v\ndiff: got->want %s", gotUsage, test.wantUsage, diff)
@@ -126,7 +142,7 @@ func TestConcurrentStore_GetWeatherByAirportCode(t *testing.T) {
 					}
 					delete(test.wantSuccess, k)
 				}
-				if diff := cmp.Diff(gotRes, wantRes); diff != "" && !test.apiMustFail {
+				if diff := cmp.Diff(gotRes, wantRes, ignoreProvenance); diff != "" && !test.apiMustFail {
 					t.Errorf("got %v, want %v\ndiff: got->want %s", gotRes, wantRes, diff)
 				}
 			}
@@ -148,21 +164,21 @@ func TestConcurrentStore_GetWeatherByCityName(t *testing.T) {
 			queries:     []string{},
 			wantErrors:  map[string]bool{},
 			wantSuccess: map[string]bool{},
-			wantUsage:   APIUsage{SuccessfulCalls: 0, FailedCalls: 0},
+			wantUsage:   APIUsage{SuccessfulCalls: 0, FailedCalls: 0, RequestsPerMinute: 60},
 		},
 		{
 			name:        "single-element cities list",
 			queries:     []string{"Mountain View"},
 			wantErrors:  map[string]bool{},
 			wantSuccess: map[string]bool{"Mountain View": true},
-			wantUsage:   APIUsage{SuccessfulCalls: 1, FailedCalls: 0},
+			wantUsage:   APIUsage{SuccessfulCalls: 1, FailedCalls: 0, RequestsPerMinute: 60},
 		},
 		{
 			name:        "multiple unique cities",
 			queries:     []string{"New York City", "San Francisco", "Seattle", "Denver", "Houston"},
 			wantErrors:  map[string]bool{},
 			wantSuccess: map[string]bool{"New York City": true, "San Francisco": true, "Seattle": true, "Denver": true, "Houston": true},
-			wantUsage:   APIUsage{SuccessfulCalls: 5, FailedCalls: 0},
+			wantUsage:   APIUsage{SuccessfulCalls: 5, FailedCalls: 0, RequestsPerMinute: 60},
 		},
 		{
 			name: "multiple repeated cities",
@@ -174,13 +190,13 @@ func TestConcurrentStore_GetWeatherByCityName(t *testing.T) {
 			},
 			wantErrors:  map[string]bool{},
 			wantSuccess: map[string]bool{"New York City": true, "San Francisco": true, "Seattle": true, "Denver": true, "Houston": true},
-			wantUsage:   APIUsage{SuccessfulCalls: 5, FailedCalls: 0},
+			wantUsage:   APIUsage{SuccessfulCalls: 5, FailedCalls: 0, RequestsPerMinute: 60},
 		},
 		{
 			name:        "failed API call",
 			queries:     []string{"New York City", "San Francisco", "Seattle", "Denver", "Houston"},
 			apiMustFail: true,
-			wantUsage:   APIUsage{SuccessfulCalls: 0, FailedCalls: 5},
+			wantUsage:   APIUsage{SuccessfulCalls: 0, FailedCalls: 5, RequestsPerMinute: 60},
 			wantErrors:  map[string]bool{"New York City": true, "San Francisco": true, "Seattle": true, "Denver": true, "Houston": true},
 			wantSuccess: map[string]bool{},
 		},
@@ -190,7 +206,7 @@ func TestConcurrentStore_GetWeatherByCityName(t *testing.T) {
 			api := openweather.NewAPIMockClient(fixedWeatherResponse)
 			api.FailNext = test.apiMustFail
 			store := NewConcurrentStore(api)
-			gotRes := store.GetWeatherByCityName(test.queries)
+			gotRes := store.GetWeatherByCityName(context.Background(), test.queries)
 			gotUsage := store.GetAPIUsage()
 			if diff := cmp.Diff(gotUsage, test.wantUsage); diff != "" {
 				t.Errorf("got usage %v, want %v\ndiff: got->want %s", gotUsage, test.wantUsage, diff)
@@ -216,10 +232,466 @@ func TestConcurrentStore_GetWeatherByCityName(t *testing.T) {
 					}
 					delete(test.wantSuccess, k)
 				}
-				if diff := cmp.Diff(gotRes, wantRes); diff != "" && !test.apiMustFail {
+				if diff := cmp.Diff(gotRes, wantRes, ignoreProvenance); diff != "" && !test.apiMustFail {
 					t.Errorf("got %v, want %v\ndiff: got->want %s", gotRes, wantRes, diff)
 				}
 			}
 		})
 	}
 }
+
+// interruptingAPI cancels the store context once its first two calls have started: the first
+// call completes and every other call blocks until release is closed.
+type interruptingAPI struct {
+	mu      sync.Mutex
+	calls   int
+	cancel  context.CancelFunc
+	second  chan struct{}
+	release chan struct{}
+}
+
+func (a *interruptingAPI) GetWeatherByCoords(_, _ float64) (*openweather.WeatherItem, error) {
+	return a.produceResponse()
+}
+
+func (a *interruptingAPI) GetWeatherByCityName(_ string) (*openweather.WeatherItem, error) {
+	return a.produceResponse()
+}
+
+func (a *interruptingAPI) produceResponse() (*openweather.WeatherItem, error) {
+	a.mu.Lock()
+	a.calls++
+	n := a.calls
+	a.mu.Unlock()
+	switch n {
+	case 1:
+		<-a.second
+		a.cancel()
+		item := fixedWeatherResponse
+		return &item, nil
+	case 2:
+		close(a.second)
+	}
+	<-a.release
+	return nil, fmt.Errorf("released")
+}
+
+func TestConcurrentStore_Interrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api := &interruptingAPI{cancel: cancel, second: make(chan struct{}), release: make(chan struct{})}
+	defer close(api.release)
+	s := NewConcurrentStore(api, WithConcurrency(2), WithGracePeriod(50*time.Millisecond))
+
+	got := s.GetWeatherByCityName(ctx, []string{"Denver", "Houston", "Seattle", "Austin", "Boston"})
+	var completed, interrupted, notAttempted int
+	for _, r := range got {
+		switch {
+		case !r.Failed:
+			completed++
+		case r.NotAttempted:
+			notAttempted++
+		default:
+			interrupted++
+		}
+	}
+	// 2 calls are in flight when interrupted: one completes and the other one is abandoned after
+	// the grace period. The remaining queries are never started.
+	if completed != 1 || interrupted != 1 || notAttempted != 3 {
+		t.Errorf("got %d completed, %d interrupted and %d not attempted queries, want 1, 1 and 3", completed, interrupted, notAttempted)
+	}
+	wantUsage := APIUsage{SuccessfulCalls: 1, FailedCalls: 1, RequestsPerMinute: 60}
+	if diff := cmp.Diff(s.GetAPIUsage(), wantUsage); diff != "" {
+		t.Errorf("got usage %v, want %v\ndiff: got->want %s", s.GetAPIUsage(), wantUsage, diff)
+	}
+}
+
+// countingAPI records the number of calls in flight and the time each call started at.
+type countingAPI struct {
+	clock    clock
+	delay    time.Duration
+	mu       sync.Mutex
+	inFlight int
+	maxIn    int
+	starts   []time.Time
+}
+
+func (a *countingAPI) GetWeatherByCoords(_, _ float64) (*openweather.WeatherItem, error) {
+	return a.produceResponse()
+}
+
+func (a *countingAPI) GetWeatherByCityName(_ string) (*openweather.WeatherItem, error) {
+	return a.produceResponse()
+}
+
+func (a *countingAPI) produceResponse() (*openweather.WeatherItem, error) {
+	a.mu.Lock()
+	a.inFlight++
+	if a.inFlight > a.maxIn {
+		a.maxIn = a.inFlight
+	}
+	a.starts = append(a.starts, a.clock.Now())
+	a.mu.Unlock()
+	time.Sleep(a.delay)
+	a.mu.Lock()
+	a.inFlight--
+	a.mu.Unlock()
+	item := fixedWeatherResponse
+	return &item, nil
+}
+
+func cityNames(n int) []string {
+	cities := make([]string, n)
+	for i := range cities {
+		cities[i] = fmt.Sprintf("city-%d", i)
+	}
+	return cities
+}
+
+func TestConcurrentStore_Concurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		queries     int
+	}{
+		{name: "sequential", concurrency: 1, queries: 10},
+		{name: "some workers", concurrency: 3, queries: 20},
+		{name: "more workers than queries", concurrency: 50, queries: 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &countingAPI{clock: realClock{}, delay: 5 * time.Millisecond}
+			s := NewConcurrentStore(api, WithConcurrency(test.concurrency), WithRequestsPerMinute(1000))
+
+			got := s.GetWeatherByCityName(context.Background(), cityNames(test.queries))
+			if len(got) != test.queries {
+				t.Errorf("GetWeatherByCityName() returned %d results, want %d", len(got), test.queries)
+			}
+			if api.maxIn > test.concurrency {
+				t.Errorf("got %d calls in flight at the same time, want at most %d", api.maxIn, test.concurrency)
+			}
+			if len(api.starts) != test.queries {
+				t.Errorf("got %d API calls, want %d", len(api.starts), test.queries)
+			}
+		})
+	}
+}
+
+func TestConcurrentStore_RequestsPerMinute(t *testing.T) {
+	tests := []struct {
+		name              string
+		requestsPerMinute int
+		concurrency       int
+		queries           int
+	}{
+		{name: "concurrency above rate", requestsPerMinute: 3, concurrency: 10, queries: 10},
+		{name: "concurrency below rate", requestsPerMinute: 4, concurrency: 2, queries: 9},
+		{name: "single call per minute", requestsPerMinute: 1, concurrency: 3, queries: 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClock()
+			api := &countingAPI{clock: c}
+			s := NewConcurrentStore(api, WithConcurrency(test.concurrency), WithRequestsPerMinute(test.requestsPerMinute), withClock(c))
+
+			got := s.GetWeatherByCityName(context.Background(), cityNames(test.queries))
+			if len(got) != test.queries {
+				t.Errorf("GetWeatherByCityName() returned %d results, want %d", len(got), test.queries)
+			}
+			if len(api.starts) != test.queries {
+				t.Fatalf("got %d API calls, want %d", len(api.starts), test.queries)
+			}
+			starts := api.starts
+			sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+			// Any rpm+1 consecutive calls must span at least a minute.
+			for i := 0; i+test.requestsPerMinute < len(starts); i++ {
+				if d := starts[i+test.requestsPerMinute].Sub(starts[i]); d < time.Minute {
+					t.Errorf("calls %d to %d started within %s, want at most %d calls per minute", i, i+test.requestsPerMinute, d, test.requestsPerMinute)
+				}
+			}
+		})
+	}
+}
+
+func TestConcurrentStore_ParallelCallers(t *testing.T) {
+	const callers = 16
+	api := &countingAPI{clock: realClock{}, delay: time.Millisecond}
+	s := NewConcurrentStore(api, WithConcurrency(3), WithRequestsPerMinute(10000))
+	airports := []Airport{{Code: "TLC"}, {Code: "MTY"}, {Code: "MEX"}, {Code: "TAM"}, {Code: "MEX"}}
+	cities := cityNames(5)
+
+	// Usage snapshots are taken while queries are running, counts must never go backwards.
+	stop := make(chan struct{})
+	snapshots := make(chan error, 1)
+	go func() {
+		var last uint
+		for {
+			select {
+			case <-stop:
+				snapshots <- nil
+				return
+			default:
+			}
+			u := s.GetAPIUsage()
+			total := u.SuccessfulCalls + u.FailedCalls
+			if total < last {
+				snapshots <- fmt.Errorf("GetAPIUsage() went from %d to %d calls", last, total)
+				return
+			}
+			last = total
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				if got := s.GetWeatherByAirportCode(context.Background(), airports); len(got) != 4 {
+					t.Errorf("GetWeatherByAirportCode() returned %d results, want 4", len(got))
+				}
+				return
+			}
+			if got := s.GetWeatherByCityName(context.Background(), cities); len(got) != 5 {
+				t.Errorf("GetWeatherByCityName() returned %d results, want 5", len(got))
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	if err := <-snapshots; err != nil {
+		t.Error(err)
+	}
+
+	// Identical queries from different callers may share a single call, see TestConcurrentStore_Coalescing.
+	usage := s.GetAPIUsage()
+	if got, want := usage.SuccessfulCalls+usage.CoalescedRequests, uint(callers/2*(4+5)); got != want {
+		t.Errorf("got %d successful and %d coalesced queries, want %d in total", usage.SuccessfulCalls, usage.CoalescedRequests, want)
+	}
+	if len(api.starts) != int(usage.SuccessfulCalls) {
+		t.Errorf("got %d API calls, want %d", len(api.starts), usage.SuccessfulCalls)
+	}
+	// The concurrency limit applies to all callers as a whole.
+	if api.maxIn > 3 {
+		t.Errorf("got %d calls in flight at the same time, want at most 3", api.maxIn)
+	}
+}
+
+// gatedAPI blocks calls for the gated city until release is closed, then fails them with err if set.
+type gatedAPI struct {
+	gated   string
+	err     error
+	called  chan struct{}
+	release chan struct{}
+	mu      sync.Mutex
+	calls   map[string]int
+}
+
+func newGatedAPI(gated string, err error) *gatedAPI {
+	return &gatedAPI{gated: gated, err: err, called: make(chan struct{}, 10), release: make(chan struct{}), calls: make(map[string]int)}
+}
+
+func (a *gatedAPI) GetWeatherByCoords(_, _ float64) (*openweather.WeatherItem, error) {
+	return nil, fmt.Errorf("unexpected call")
+}
+
+func (a *gatedAPI) GetWeatherByCityName(cityName string) (*openweather.WeatherItem, error) {
+	a.mu.Lock()
+	a.calls[cityName]++
+	a.mu.Unlock()
+	if cityName == a.gated {
+		a.called <- struct{}{}
+		<-a.release
+		if a.err != nil {
+			return nil, a.err
+		}
+	}
+	item := fixedWeatherResponse
+	return &item, nil
+}
+
+// waitForWaiters blocks until n callers joined the flight of the given city query.
+func waitForWaiters(t *testing.T, s *ConcurrentStore, city string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		f, ok := s.flights[queryID(cityQuery, city)]
+		joined := ok && f.waiters >= n
+		s.mu.Unlock()
+		if joined {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d callers to join the %q flight", n, city)
+}
+
+func TestConcurrentStore_Coalescing(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantFailed bool
+		wantUsage  APIUsage
+	}{
+		{
+			name:      "shared result",
+			wantUsage: APIUsage{SuccessfulCalls: 2, CoalescedRequests: 3, RequestsPerMinute: 60},
+		},
+		{
+			name:       "shared error",
+			err:        openweather.ErrNotFound,
+			wantFailed: true,
+			wantUsage:  APIUsage{SuccessfulCalls: 1, FailedCalls: 1, CoalescedRequests: 3, RequestsPerMinute: 60},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newGatedAPI("Toluca", test.err)
+			s := NewConcurrentStore(api).(*ConcurrentStore)
+
+			first := make(chan map[string]WeatherReport)
+			go func() { first <- s.GetWeatherByCityName(context.Background(), []string{"Toluca"}) }()
+			<-api.called
+			others := make(chan map[string]WeatherReport, 2)
+			for i := 0; i < 2; i++ {
+				go func() { others <- s.GetWeatherByCityName(context.Background(), []string{"Toluca", "Monterrey"}) }()
+			}
+			waitForWaiters(t, s, "Toluca", 2)
+			close(api.release)
+
+			want := (<-first)["Toluca"]
+			if want.Failed != test.wantFailed {
+				t.Errorf("got report %v, want failed %t", want, test.wantFailed)
+			}
+			if want.Provenance.SharedFrom != "" {
+				t.Errorf("got leader report shared from %q, want none", want.Provenance.SharedFrom)
+			}
+			// The first fetch led the flight, the others joined it.
+			const leader = "city Toluca of fetch 1"
+			for i := 0; i < 2; i++ {
+				got := <-others
+				if diff := cmp.Diff(got["Toluca"], want, ignoreProvenance); diff != "" {
+					t.Errorf("got %v, want %v\ndiff: got->want %s", got["Toluca"], want, diff)
+				}
+				if p := got["Toluca"].Provenance; p.SharedFrom != leader || p.FetchedAt != want.Provenance.FetchedAt {
+					t.Errorf("got provenance %+v, want the one of %+v shared from %q", p, want.Provenance, leader)
+				}
+			}
+			if api.calls["Toluca"] != 1 {
+				t.Errorf("got %d API calls for Toluca, want 1", api.calls["Toluca"])
+			}
+			// Both Monterrey queries may or may not overlap, usage is normalized as if they were coalesced.
+			usage := s.GetAPIUsage()
+			usage.SuccessfulCalls -= uint(api.calls["Monterrey"] - 1)
+			usage.CoalescedRequests += uint(api.calls["Monterrey"] - 1)
+			if diff := cmp.Diff(usage, test.wantUsage); diff != "" {
+				t.Errorf("got usage %v, want %v\ndiff: got->want %s", usage, test.wantUsage, diff)
+			}
+		})
+	}
+}
+
+func TestConcurrentStore_CoalescingAbandonedCall(t *testing.T) {
+	api := newGatedAPI("", nil)
+	s := NewConcurrentStore(api).(*ConcurrentStore)
+	// Another caller is about to call the API for Toluca but gives up, e.g: it was interrupted.
+	f, leader := s.join(cityQuery, "Toluca", 0)
+	if !leader {
+		t.Fatalf("join(city, Toluca) returned an existing flight, want a new one")
+	}
+	got := make(chan map[string]WeatherReport)
+	go func() { got <- s.GetWeatherByCityName(context.Background(), []string{"Toluca"}) }()
+	waitForWaiters(t, s, "Toluca", 1)
+	s.land(cityQuery, "Toluca", f, nil)
+
+	if r := (<-got)["Toluca"]; r.Failed {
+		t.Errorf("GetWeatherByCityName(Toluca) returned failed report %v, want successful", r)
+	}
+	if api.calls["Toluca"] != 1 {
+		t.Errorf("got %d API calls for Toluca, want 1", api.calls["Toluca"])
+	}
+	want := APIUsage{SuccessfulCalls: 1, RequestsPerMinute: 60}
+	if diff := cmp.Diff(s.GetAPIUsage(), want); diff != "" {
+		t.Errorf("got usage %v, want %v\ndiff: got->want %s", s.GetAPIUsage(), want, diff)
+	}
+}
+
+func TestConcurrentStore_join(t *testing.T) {
+	s := NewConcurrentStore(newGatedAPI("", nil)).(*ConcurrentStore)
+	if _, leader := s.join(airportQuery, "MEX", 0); !leader {
+		t.Errorf("join(airport, MEX) returned an existing flight, want a new one")
+	}
+	if _, leader := s.join(cityQuery, "MEX", 0); !leader {
+		t.Errorf("join(city, MEX) after join(airport, MEX) returned an existing flight, want a new one")
+	}
+	if _, leader := s.join(airportQuery, "MEX", 0); leader {
+		t.Errorf("second join(airport, MEX) returned a new flight, want the existing one")
+	}
+}
+
+func TestRequestResult_reportNotFound(t *testing.T) {
+	tests := []struct {
+		err          error
+		wantNotFound bool
+	}{
+		{err: fmt.Errorf("%w: %q", openweather.ErrNotFound, "city not found"), wantNotFound: true},
+		{err: openweather.ErrServer},
+		{err: errInterrupted},
+	}
+	for _, test := range tests {
+		r := (&requestResult{key: "Atlantis", err: test.err}).report()
+		if !r.Failed || r.NotFound != test.wantNotFound {
+			t.Errorf("report() of error %v returned failed %t and not found %t, want true and %t", test.err, r.Failed, r.NotFound, test.wantNotFound)
+		}
+	}
+}
+
+// slowAPI takes delay to answer every call according to clock, as if each answer required attempts.
+type slowAPI struct {
+	clock    clock
+	delay    time.Duration
+	attempts int
+}
+
+func (a *slowAPI) GetWeatherByCoords(_, _ float64) (*openweather.WeatherItem, error) {
+	return a.produceResponse()
+}
+
+func (a *slowAPI) GetWeatherByCityName(_ string) (*openweather.WeatherItem, error) {
+	return a.produceResponse()
+}
+
+func (a *slowAPI) produceResponse() (*openweather.WeatherItem, error) {
+	<-a.clock.After(a.delay)
+	item := fixedWeatherResponse
+	item.Endpoint = "https://api.openweathermap.org/data/2.5/weather"
+	item.Attempts = a.attempts
+	return &item, nil
+}
+
+func TestConcurrentStore_Provenance(t *testing.T) {
+	c := newFakeClock()
+	start := c.Now()
+	api := &slowAPI{clock: c, delay: 2 * time.Second, attempts: 3}
+	s := NewConcurrentStore(api, WithConcurrency(1), withClock(c))
+
+	got := s.GetWeatherByCityName(context.Background(), []string{"Toluca"})["Toluca"].Provenance
+	want := Provenance{
+		Provider:  openweather.ProviderName,
+		Endpoint:  "https://api.openweathermap.org/data/2.5/weather",
+		FetchedAt: start.Add(2 * time.Second),
+		Latency:   2 * time.Second,
+		Attempts:  3,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("got provenance %+v, want %+v\ndiff: got->want %s", got, want, diff)
+	}
+}
+
+func TestDefaultConcurrency_fitsSharedTransport(t *testing.T) {
+	if openweather.DefaultMaxIdleConnsPerHost < DefaultConcurrency {
+		t.Errorf("openweather.DefaultMaxIdleConnsPerHost = %d, want at least DefaultConcurrency (%d)", openweather.DefaultMaxIdleConnsPerHost, DefaultConcurrency)
+	}
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// journalRotationSuffix is appended to the location of journals holding the entries of a previous
+// run when a new one starts from scratch.
+const journalRotationSuffix = ".prev"
+
+// Journal is an append-only file where the report of each completed query is recorded as soon as
+// it is available, so long runs can be resumed after a crash or interruption. A journal belongs to
+// a single dataset since queries are identified by their key only (airport code or city name).
+type Journal struct {
+	mu   sync.Mutex
+	f    *os.File
+	path string
+	// rotated is where the entries of a previous run were moved, if any, see OpenJournal.
+	rotated string
+	// completed holds the successful reports recorded by previous runs, by query key.
+	completed map[string]WeatherReport
+}
+
+// journalEntry is a single journal line.
+type journalEntry struct {
+	Key    string        `json:"key"`
+	Report WeatherReport `json:"report"`
+}
+
+// OpenJournal opens the journal at path, creating it if needed. If resume is true, entries
+// recorded by previous runs are loaded and new ones are appended, otherwise the journal starts
+// empty and the entries of previous runs, if any, are moved next to it (see Rotated) rather than
+// discarded, replacing the ones moved before.
+func OpenJournal(path string, resume bool) (*Journal, error) {
+	j := &Journal{path: path, completed: make(map[string]WeatherReport)}
+	var end int64
+	if resume {
+		var err error
+		if end, err = j.load(); err != nil {
+			return nil, err
+		}
+	} else if err := j.rotate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed opening journal: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening journal: %v", err)
+	}
+	// Drop a torn last line, new entries would be appended to it otherwise.
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed opening journal: %v", err)
+	}
+	j.f = f
+	return j, nil
+}
+
+// load reads the entries recorded by previous runs and returns the offset where the last complete
+// one ends. A malformed or unterminated last line, e.g: written while the process was being killed,
+// is ignored.
+func (j *Journal) load() (int64, error) {
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed opening journal: %v", err)
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	var end int64
+	line := 0
+	var malformed error
+	for {
+		b, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Unterminated lines weren't fully written.
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed reading journal: %v", err)
+		}
+		line++
+		if malformed != nil {
+			return 0, malformed
+		}
+		var e journalEntry
+		if err := json.Unmarshal(b, &e); err != nil {
+			malformed = fmt.Errorf("malformed journal %s at line %d: %v", j.path, line, err)
+			continue
+		}
+		end += int64(len(b))
+		if e.Report.Failed {
+			// Failed queries are retried when resuming.
+			delete(j.completed, e.Key)
+			continue
+		}
+		j.completed[e.Key] = e.Report
+	}
+	return end, nil
+}
+
+// rotate moves the entries of previous runs, if any, to the rotated journal location.
+func (j *Journal) rotate() error {
+	info, err := os.Stat(j.path)
+	if os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed opening journal: %v", err)
+	}
+	rotated := j.path + journalRotationSuffix
+	if err := os.Rename(j.path, rotated); err != nil {
+		return fmt.Errorf("failed keeping previous journal: %v", err)
+	}
+	j.rotated = rotated
+	return nil
+}
+
+// Rotated returns where the entries of a previous run were moved when opening the journal without
+// resuming, empty if there were none. They can be resumed by opening the journal at that location.
+func (j *Journal) Rotated() string {
+	return j.rotated
+}
+
+// Path returns the journal file location.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Completed returns the successful reports recorded by previous runs, by query key.
+func (j *Journal) Completed() map[string]WeatherReport {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	completed := make(map[string]WeatherReport, len(j.completed))
+	for k, r := range j.completed {
+		completed[k] = r
+	}
+	return completed
+}
+
+// Record appends the report of a completed query. It is safe for concurrent use.
+func (j *Journal) Record(key string, r WeatherReport) error {
+	line, err := json.Marshal(journalEntry{Key: key, Report: r})
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed writing journal: %v", err)
+	}
+	return nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
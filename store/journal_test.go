@@ -0,0 +1,207 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJournal_Resume(t *testing.T) {
+	path := filepath.Join(tempDir(t), "dataset.journal")
+	j, err := OpenJournal(path, false)
+	if err != nil {
+		t.Fatalf("OpenJournal(%s, false) returned unexpected error: %v", path, err)
+	}
+	failed := WeatherReport{Failed: true, FailMessage: "exceeded requests limit"}
+	for key, r := range map[string]WeatherReport{"TLC": fixedWeatherReport, "MTY": failed, "MEX": fixedWeatherReport} {
+		if err := j.Record(key, r); err != nil {
+			t.Fatalf("Record(%s) returned unexpected error: %v", key, err)
+		}
+	}
+	// A query failing on a first attempt and succeeding later is completed.
+	j.Record("TAM", failed)
+	j.Record("TAM", fixedWeatherReport)
+	j.Close()
+	// Simulate the process being killed while writing an entry.
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write([]byte(`{"key": "CUN", "rep`))
+	f.Close()
+
+	resumed, err := OpenJournal(path, true)
+	if err != nil {
+		t.Fatalf("OpenJournal(%s, true) returned unexpected error: %v", path, err)
+	}
+	want := map[string]WeatherReport{"TLC": fixedWeatherReport, "MEX": fixedWeatherReport, "TAM": fixedWeatherReport}
+	if diff := cmp.Diff(resumed.Completed(), want); diff != "" {
+		t.Errorf("Completed(): %v, want %v\ndiff: got->want %s", resumed.Completed(), want, diff)
+	}
+
+	resumed.Close()
+
+	// Opening without resuming starts from scratch, keeping the entries of previous runs aside.
+	previous, _ := ioutil.ReadFile(path)
+	fresh, err := OpenJournal(path, false)
+	if err != nil {
+		t.Fatalf("OpenJournal(%s, false) returned unexpected error: %v", path, err)
+	}
+	fresh.Close()
+	if content, _ := ioutil.ReadFile(path); len(content) != 0 {
+		t.Errorf("OpenJournal(%s, false) kept %d bytes of previous runs, want 0", path, len(content))
+	}
+	if got, want := fresh.Rotated(), path+".prev"; got != want {
+		t.Fatalf("Rotated() = %q, want %q", got, want)
+	}
+	if rotated, _ := ioutil.ReadFile(fresh.Rotated()); string(rotated) != string(previous) {
+		t.Errorf("got rotated journal %q, want the previous one %q", rotated, previous)
+	}
+	rotated, err := OpenJournal(fresh.Rotated(), true)
+	if err != nil {
+		t.Fatalf("OpenJournal(%s, true) returned unexpected error: %v", fresh.Rotated(), err)
+	}
+	defer rotated.Close()
+	if diff := cmp.Diff(rotated.Completed(), want); diff != "" {
+		t.Errorf("Completed() of the rotated journal: %v, want %v\ndiff: got->want %s", rotated.Completed(), want, diff)
+	}
+
+	// Empty journals are just reused.
+	again, err := OpenJournal(path, false)
+	if err != nil {
+		t.Fatalf("OpenJournal(%s, false) returned unexpected error: %v", path, err)
+	}
+	again.Close()
+	if again.Rotated() != "" {
+		t.Errorf("Rotated() = %q after opening an empty journal, want none", again.Rotated())
+	}
+}
+
+func TestJournal_ResumeAfterTornEntry(t *testing.T) {
+	path := filepath.Join(tempDir(t), "dataset.journal")
+	ioutil.WriteFile(path, []byte("{\"key\": \"MEX\", \"report\": {}}\n{\"key\":\"TLC\",\"rep"), 0644)
+
+	// Each run records a query and is resumed by the next one.
+	want := map[string]WeatherReport{"MEX": {}}
+	for _, key := range []string{"MTY", "TAM"} {
+		j, err := OpenJournal(path, true)
+		if err != nil {
+			t.Fatalf("OpenJournal(%s, true) returned unexpected error: %v", path, err)
+		}
+		if err := j.Record(key, fixedWeatherReport); err != nil {
+			t.Fatalf("Record(%s) returned unexpected error: %v", key, err)
+		}
+		j.Close()
+		want[key] = fixedWeatherReport
+	}
+
+	resumed, err := OpenJournal(path, true)
+	if err != nil {
+		t.Fatalf("OpenJournal(%s, true) returned unexpected error: %v", path, err)
+	}
+	defer resumed.Close()
+	if diff := cmp.Diff(resumed.Completed(), want); diff != "" {
+		t.Errorf("Completed(): %v, want %v\ndiff: got->want %s", resumed.Completed(), want, diff)
+	}
+}
+
+func TestJournal_MissingDirectory(t *testing.T) {
+	// e.g: the user cache directory on a machine where nothing was cached yet.
+	path := filepath.Join(tempDir(t), "cache", "weatherreport", "stdin.journal")
+	j, err := OpenJournal(path, false)
+	if err != nil {
+		t.Fatalf("OpenJournal(%s, false) returned unexpected error: %v", path, err)
+	}
+	if err := j.Record("MEX", fixedWeatherReport); err != nil {
+		t.Fatalf("Record(MEX) returned unexpected error: %v", err)
+	}
+	j.Close()
+
+	resumed, err := OpenJournal(path, true)
+	if err != nil {
+		t.Fatalf("OpenJournal(%s, true) returned unexpected error: %v", path, err)
+	}
+	defer resumed.Close()
+	want := map[string]WeatherReport{"MEX": fixedWeatherReport}
+	if diff := cmp.Diff(resumed.Completed(), want); diff != "" {
+		t.Errorf("Completed(): %v, want %v\ndiff: got->want %s", resumed.Completed(), want, diff)
+	}
+}
+
+func TestJournal_MalformedEntry(t *testing.T) {
+	path := filepath.Join(tempDir(t), "dataset.journal")
+	ioutil.WriteFile(path, []byte("not json\n{\"key\": \"TLC\", \"report\": {}}\n"), 0644)
+	if _, err := OpenJournal(path, true); err == nil {
+		t.Errorf("OpenJournal(%s, true) with a malformed entry returned nil error, want error", path)
+	}
+}
+
+func TestConcurrentStore_WithJournal(t *testing.T) {
+	path := filepath.Join(tempDir(t), "dataset.journal")
+	j, _ := OpenJournal(path, false)
+	j.Record("Denver", fixedWeatherReport)
+	j.Record("Houston", WeatherReport{Failed: true, FailMessage: "exceeded requests limit"})
+	j.Close()
+
+	j, err := OpenJournal(path, true)
+	if err != nil {
+		t.Fatalf("OpenJournal(%s, true) returned unexpected error: %v", path, err)
+	}
+	api := &fakeKeyedAPI{id: "a"}
+	s := NewConcurrentStore(api, WithJournal(j))
+	queries := []string{"Denver", "Houston", "Seattle", "Denver"}
+
+	gotPlan := s.(Planner).PlanWeatherByCityName(queries)
+	if gotPlan.Cached != 1 || gotPlan.Calls != 2 {
+		t.Errorf("PlanWeatherByCityName(%v) returned %d cached and %d calls, want 1 and 2", queries, gotPlan.Cached, gotPlan.Calls)
+	}
+
+	got := s.GetWeatherByCityName(context.Background(), queries)
+	j.Close()
+	if api.calls != 2 {
+		t.Errorf("GetWeatherByCityName(%v) performed %d API calls, want 2", queries, api.calls)
+	}
+	if len(got) != 3 {
+		t.Fatalf("GetWeatherByCityName(%v) returned %d results, want 3", queries, len(got))
+	}
+	for k, r := range got {
+		if diff := cmp.Diff(r, fixedWeatherReport, ignoreProvenance); diff != "" {
+			t.Errorf("GetWeatherByCityName(%v)[%s]: %v, want %v\ndiff: got->want %s", queries, k, r, fixedWeatherReport, diff)
+		}
+	}
+
+	// Every query completed by this run is journaled too.
+	resumed, _ := OpenJournal(path, true)
+	defer resumed.Close()
+	if n := len(resumed.Completed()); n != 3 {
+		t.Errorf("journal has %d completed queries, want 3", n)
+	}
+}
+
+func TestConcurrentStore_WithJournalFlagsStale(t *testing.T) {
+	c := newFakeClock()
+	path := filepath.Join(tempDir(t), "dataset.journal")
+	j, _ := OpenJournal(path, false)
+	j.Record("Denver", WeatherReport{CityName: "Denver", ObservationTime: c.Now()})
+	j.Record("Houston", WeatherReport{CityName: "Houston", ObservationTime: c.Now().Add(90 * time.Minute)})
+	j.Close()
+
+	// The run is resumed two hours later, Denver's observation aged past the threshold meanwhile.
+	c.Advance(2 * time.Hour)
+	j, err := OpenJournal(path, true)
+	if err != nil {
+		t.Fatalf("OpenJournal(%s, true) returned unexpected error: %v", path, err)
+	}
+	defer j.Close()
+	s := NewConcurrentStore(&fakeKeyedAPI{id: "a"}, WithJournal(j), WithStaleAfter(time.Hour), withClock(c))
+
+	got := s.GetWeatherByCityName(context.Background(), []string{"Denver", "Houston"})
+	if !got["Denver"].Stale {
+		t.Errorf("got resumed Denver report %v, want stale", got["Denver"])
+	}
+	if got["Houston"].Stale {
+		t.Errorf("got resumed Houston report %v, want not stale", got["Houston"])
+	}
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+// ErrNoUsableKeys is returned by KeyPool when every API key in the pool has been disabled.
+var ErrNoUsableKeys = errors.New("all API keys are disabled")
+
+// KeyedAPI is an OpenWeather API client bound to a single API key, e.g: *openweather.APIClient.
+type KeyedAPI interface {
+	openweather.API
+	// KeyID returns an identifier of the API key that is safe to be displayed, i.e: not the key itself.
+	KeyID() string
+}
+
+// KeyUsage contains usage statistics of a single API key.
+type KeyUsage struct {
+	// KeyID identifies the API key without revealing it.
+	KeyID string
+	// SuccessfulCalls count.
+	SuccessfulCalls uint
+	// FailedCalls count, including rate-limited and unauthorized calls.
+	FailedCalls uint
+	// RateLimitedCalls count, i.e: calls rejected because the key exceeded its requests limit.
+	RateLimitedCalls uint
+	// Disabled indicates the key was rejected as unauthorized or exhausted its quota and is no
+	// longer used.
+	Disabled bool
+}
+
+// pooledKey is a KeyPool member.
+type pooledKey struct {
+	api     KeyedAPI
+	limiter *rateLimiter
+	usage   KeyUsage
+}
+
+// KeyPool is an openweather.API implementation that spreads calls across several API keys, each
+// one with its own rate limiter. Keys rejected as unauthorized or out of quota (see Quota) are
+// disabled and rate-limited keys are left to cool down, in all cases the call is retried with
+// another key.
+type KeyPool struct {
+	mu    sync.Mutex
+	clock clock
+	keys  []*pooledKey
+	// next is the index of the key the next call starts looking from (round robin).
+	next int
+	// requestsPerMinute allowed for each key.
+	requestsPerMinute int
+}
+
+// NewKeyPool returns a pool performing up to requestsPerMinute calls per minute with each client.
+func NewKeyPool(requestsPerMinute int, clients ...KeyedAPI) (*KeyPool, error) {
+	return newKeyPool(realClock{}, requestsPerMinute, clients...)
+}
+
+func newKeyPool(c clock, requestsPerMinute int, clients ...KeyedAPI) (*KeyPool, error) {
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("got empty list of API clients")
+	}
+	if requestsPerMinute <= 0 {
+		return nil, fmt.Errorf("got invalid requests per minute %d, want a positive number", requestsPerMinute)
+	}
+	p := &KeyPool{clock: c, requestsPerMinute: requestsPerMinute}
+	for _, api := range clients {
+		p.keys = append(p.keys, &pooledKey{
+			api:     api,
+			limiter: newRateLimiter(c, requestsPerMinute, time.Minute),
+			usage:   KeyUsage{KeyID: api.KeyID()},
+		})
+	}
+	return p, nil
+}
+
+// RequestsPerMinute returns the aggregated number of calls per minute the pool can perform.
+func (p *KeyPool) RequestsPerMinute() int {
+	return p.requestsPerMinute * len(p.keys)
+}
+
+// GetWeatherByCoords returns the current weather at the given location using the next available key.
+func (p *KeyPool) GetWeatherByCoords(lat, lon float64) (*openweather.WeatherItem, error) {
+	return p.call(func(api openweather.API) (*openweather.WeatherItem, error) {
+		return api.GetWeatherByCoords(lat, lon)
+	})
+}
+
+// GetWeatherByCityName returns the current weather at the given city name using the next available key.
+func (p *KeyPool) GetWeatherByCityName(cityName string) (*openweather.WeatherItem, error) {
+	return p.call(func(api openweather.API) (*openweather.WeatherItem, error) {
+		return api.GetWeatherByCityName(cityName)
+	})
+}
+
+// KeyUsage returns usage statistics of each key in the pool.
+func (p *KeyPool) KeyUsage() []KeyUsage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	usage := make([]KeyUsage, len(p.keys))
+	for i, k := range p.keys {
+		usage[i] = k.usage
+	}
+	return usage
+}
+
+// call performs f with the next available key, rotating away from keys that are rejected as
+// unauthorized, rate-limited or out of quota. Each key is tried at most twice per call.
+func (p *KeyPool) call(f func(openweather.API) (*openweather.WeatherItem, error)) (*openweather.WeatherItem, error) {
+	var lastErr error
+	calls := 0
+	for attempt := 0; attempt < 2*len(p.keys); attempt++ {
+		k, wait, err := p.acquire()
+		if err != nil {
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w, last error: %v", err, lastErr)
+			}
+			return nil, err
+		}
+		if k == nil {
+			<-p.clock.After(wait)
+			attempt--
+			continue
+		}
+		item, err := f(k.api)
+		calls++
+		p.record(k, err)
+		if rotatable(err) {
+			lastErr = err
+			continue
+		}
+		if item != nil {
+			item.Attempts = calls
+		}
+		return item, err
+	}
+	return nil, lastErr
+}
+
+// acquire returns the next enabled key allowed to perform a call by its rate limiter. If none is
+// allowed right now, it returns a nil key and the time to wait before trying again.
+func (p *KeyPool) acquire() (*pooledKey, time.Duration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var minWait time.Duration
+	enabled := 0
+	for i := range p.keys {
+		k := p.keys[(p.next+i)%len(p.keys)]
+		if k.usage.Disabled {
+			continue
+		}
+		enabled++
+		wait := k.limiter.reserve()
+		if wait == 0 {
+			p.next = (p.next + i + 1) % len(p.keys)
+			return k, 0, nil
+		}
+		if minWait == 0 || wait < minWait {
+			minWait = wait
+		}
+	}
+	if enabled == 0 {
+		return nil, 0, ErrNoUsableKeys
+	}
+	return nil, minWait, nil
+}
+
+// record updates the key usage statistics with the result of a call.
+func (p *KeyPool) record(k *pooledKey, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case err == nil:
+		k.usage.SuccessfulCalls++
+		return
+	case errors.Is(err, ErrQuotaExceeded):
+		// The call was refused before reaching the API.
+		k.usage.Disabled = true
+		return
+	case errors.Is(err, openweather.ErrUnauthorized):
+		k.usage.Disabled = true
+	case errors.Is(err, openweather.ErrRateLimited):
+		k.usage.RateLimitedCalls++
+		k.limiter.block()
+	}
+	k.usage.FailedCalls++
+}
+
+// rotatable returns whether a call that failed with err should be retried with another key.
+func rotatable(err error) bool {
+	return errors.Is(err, openweather.ErrUnauthorized) ||
+		errors.Is(err, openweather.ErrRateLimited) ||
+		errors.Is(err, ErrQuotaExceeded)
+}
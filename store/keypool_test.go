@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+// fakeKeyedAPI is a KeyedAPI whose calls fail with the errors in errs, in order, and then succeed.
+type fakeKeyedAPI struct {
+	mu    sync.Mutex
+	id    string
+	errs  []error
+	calls int
+}
+
+func (f *fakeKeyedAPI) KeyID() string {
+	return f.id
+}
+
+func (f *fakeKeyedAPI) GetWeatherByCoords(_, _ float64) (*openweather.WeatherItem, error) {
+	return f.produceResponse()
+}
+
+func (f *fakeKeyedAPI) GetWeatherByCityName(_ string) (*openweather.WeatherItem, error) {
+	return f.produceResponse()
+}
+
+func (f *fakeKeyedAPI) produceResponse() (*openweather.WeatherItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	item := fixedWeatherResponse
+	return &item, nil
+}
+
+func TestNewKeyPool(t *testing.T) {
+	if _, err := NewKeyPool(60); err == nil {
+		t.Errorf("NewKeyPool(60) returned nil error, want error")
+	}
+	if _, err := NewKeyPool(0, &fakeKeyedAPI{id: "a"}); err == nil {
+		t.Errorf("NewKeyPool(0, a) returned nil error, want error")
+	}
+	p, err := NewKeyPool(60, &fakeKeyedAPI{id: "a"}, &fakeKeyedAPI{id: "b"})
+	if err != nil {
+		t.Fatalf("NewKeyPool(60, a, b) returned unexpected error: %v", err)
+	}
+	if got := p.RequestsPerMinute(); got != 120 {
+		t.Errorf("RequestsPerMinute(): %d, want 120", got)
+	}
+}
+
+func TestKeyPool_SpreadsCalls(t *testing.T) {
+	c := newFakeClock()
+	a, b := &fakeKeyedAPI{id: "a"}, &fakeKeyedAPI{id: "b"}
+	p, _ := newKeyPool(c, 2, a, b)
+
+	start := c.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := p.GetWeatherByCityName("Toluca"); err != nil {
+			t.Fatalf("GetWeatherByCityName(Toluca) returned unexpected error: %v", err)
+		}
+	}
+	// Two keys with 2 calls/minute each perform 10 calls in 2 minutes: 4 at 0m, 4 at 1m and 2 at 2m.
+	if elapsed := c.Now().Sub(start); elapsed != 2*time.Minute {
+		t.Errorf("10 calls took %s, want 2m", elapsed)
+	}
+	want := []KeyUsage{{KeyID: "a", SuccessfulCalls: 5}, {KeyID: "b", SuccessfulCalls: 5}}
+	if diff := cmp.Diff(p.KeyUsage(), want); diff != "" {
+		t.Errorf("KeyUsage(): %v, want %v\ndiff: got->want %s", p.KeyUsage(), want, diff)
+	}
+}
+
+func TestKeyPool_Rotation(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      map[string][]error
+		wantErr   error
+		wantUsage []KeyUsage
+		// wantAttempts of the first call, if successful.
+		wantAttempts int
+	}{
+		{
+			name:         "unauthorized key is disabled",
+			errs:         map[string][]error{"a": {openweather.ErrUnauthorized}},
+			wantAttempts: 2,
+			wantUsage: []KeyUsage{
+				{KeyID: "a", FailedCalls: 1, Disabled: true},
+				{KeyID: "b", SuccessfulCalls: 3},
+			},
+		},
+		{
+			name:         "rate-limited key cools down",
+			errs:         map[string][]error{"a": {openweather.ErrRateLimited}},
+			wantAttempts: 2,
+			wantUsage: []KeyUsage{
+				{KeyID: "a", FailedCalls: 1, RateLimitedCalls: 1},
+				{KeyID: "b", SuccessfulCalls: 3},
+			},
+		},
+		{
+			name: "other errors are not retried",
+			errs: map[string][]error{"a": {openweather.ErrNotFound}},
+			wantUsage: []KeyUsage{
+				{KeyID: "a", SuccessfulCalls: 1, FailedCalls: 1},
+				{KeyID: "b", SuccessfulCalls: 1},
+			},
+			wantErr: openweather.ErrNotFound,
+		},
+		{
+			name: "all keys unauthorized",
+			errs: map[string][]error{
+				"a": {openweather.ErrUnauthorized},
+				"b": {openweather.ErrUnauthorized},
+			},
+			wantUsage: []KeyUsage{
+				{KeyID: "a", FailedCalls: 1, Disabled: true},
+				{KeyID: "b", FailedCalls: 1, Disabled: true},
+			},
+			wantErr: ErrNoUsableKeys,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &fakeKeyedAPI{id: "a", errs: test.errs["a"]}
+			b := &fakeKeyedAPI{id: "b", errs: test.errs["b"]}
+			p, _ := newKeyPool(newFakeClock(), 60, a, b)
+
+			item, err := p.GetWeatherByCoords(1, 2)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("GetWeatherByCoords(1, 2) returned error %v, want %v", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("GetWeatherByCoords(1, 2) returned unexpected error: %v", err)
+			} else if item.Attempts != test.wantAttempts {
+				t.Errorf("GetWeatherByCoords(1, 2) took %d attempts, want %d", item.Attempts, test.wantAttempts)
+			}
+			if test.wantErr == nil || test.wantErr == openweather.ErrNotFound {
+				for i := 0; i < 2; i++ {
+					if _, err := p.GetWeatherByCoords(1, 2); err != nil {
+						t.Fatalf("GetWeatherByCoords(1, 2) returned unexpected error: %v", err)
+					}
+				}
+			}
+			if diff := cmp.Diff(p.KeyUsage(), test.wantUsage); diff != "" {
+				t.Errorf("KeyUsage(): %v, want %v\ndiff: got->want %s", p.KeyUsage(), test.wantUsage, diff)
+			}
+		})
+	}
+}
+
+func TestConcurrentStore_GetAPIUsageWithKeyPool(t *testing.T) {
+	p, _ := newKeyPool(newFakeClock(), 60, &fakeKeyedAPI{id: "a"}, &fakeKeyedAPI{id: "b"})
+	s := NewConcurrentStore(p, WithRequestsPerMinute(p.RequestsPerMinute()))
+	s.GetWeatherByCityName(context.Background(), []string{"Toluca", "Monterrey", "Tampico", "Mexico City"})
+
+	got := s.GetAPIUsage()
+	want := APIUsage{
+		SuccessfulCalls:   4,
+		RequestsPerMinute: 120,
+		Keys:              []KeyUsage{{KeyID: "a", SuccessfulCalls: 2}, {KeyID: "b", SuccessfulCalls: 2}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("GetAPIUsage(): %v, want %v\ndiff: got->want %s", got, want, diff)
+	}
+}
@@ -1,11 +1,14 @@
 package openweather
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 const (
@@ -13,59 +16,132 @@ const (
 	currentWeatherPath = "weather"
 )
 
+// supportedLanguages are the language codes accepted by OpenWeather's lang parameter.
+// See https://openweathermap.org/current#multi.
+var supportedLanguages = map[string]bool{
+	"af": true, "al": true, "ar": true, "az": true, "bg": true, "ca": true, "cz": true, "da": true,
+	"de": true, "el": true, "en": true, "es": true, "eu": true, "fa": true, "fi": true, "fr": true,
+	"gl": true, "he": true, "hi": true, "hr": true, "hu": true, "id": true, "it": true, "ja": true,
+	"kr": true, "la": true, "lt": true, "mk": true, "nl": true, "no": true, "pl": true, "pt": true,
+	"pt_br": true, "ro": true, "ru": true, "se": true, "sk": true, "sl": true, "sp": true, "sr": true,
+	"sv": true, "th": true, "tr": true, "ua": true, "uk": true, "vi": true, "zh_cn": true, "zh_tw": true,
+	"zu": true,
+}
+
 // APIClient is an API implementation.
 type APIClient struct {
 	apiKey string
 	apiURL string
-	units  string
+	units  Units
+	lang   string
 	client *http.Client
 }
 
+// Option configures optional APIClient settings.
+type Option func(*APIClient) error
+
+// WithLanguage makes the client request weather descriptions in the given language,
+// e.g: "es" for Spanish. Defaults to OpenWeather's default language (English).
+func WithLanguage(lang string) Option {
+	return func(c *APIClient) error {
+		lang = strings.ToLower(lang)
+		if !supportedLanguages[lang] {
+			return fmt.Errorf("got unsupported language %q, see https://openweathermap.org/current#multi", lang)
+		}
+		c.lang = lang
+		return nil
+	}
+}
+
+// WithBaseURL makes the client send requests to the given base URL instead of OpenWeather's
+// production API, e.g: a proxy or test server.
+func WithBaseURL(u string) Option {
+	return func(c *APIClient) error {
+		if _, err := url.Parse(u); err != nil {
+			return fmt.Errorf("got invalid base URL %q: %v", u, err)
+		}
+		c.apiURL = u
+		return nil
+	}
+}
+
 // NewAPIClient returns an Open Weather API client that uses the given API key and units system.
-func NewAPIClient(apiKey, units string) (*APIClient, error) {
+func NewAPIClient(apiKey, units string, opts ...Option) (*APIClient, error) {
 	if apiKey == "" {
 		return nil, fmt.Errorf("got empty API key")
 	}
-	if _, ok := map[string]bool{"standard": true, "metric": true, "imperial": true}[units]; !ok {
-		return nil, fmt.Errorf("got invalid units value %s, want one of standard, metric, or imperial", units)
+	u, err := ParseUnits(units)
+	if err != nil {
+		return nil, err
+	}
+	c := &APIClient{
+		apiKey: apiKey,
+		units:  u,
+		apiURL: baseURL,
+		client: &http.Client{Transport: sharedTransport, Timeout: requestTimeout},
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
 	}
-	return &APIClient{apiKey: apiKey, units: units, apiURL: baseURL, client: &http.Client{}}, nil
+	return c, nil
+}
+
+// KeyID returns an identifier of the client API key that is safe to be displayed or stored, i.e:
+// the first 8 hex characters of the key's SHA-256 digest.
+func (c *APIClient) KeyID() string {
+	sum := sha256.Sum256([]byte(c.apiKey))
+	return hex.EncodeToString(sum[:4])
 }
 
 // GetWeatherByCoords returns the current weather at the given location.
 // It mirrors https://openweathermap.org/current.
 func (c *APIClient) GetWeatherByCoords(lat, lon float64) (*WeatherItem, error) {
-	res, err := c.makeHTTPCall(currentWeatherPath, map[string]string{
+	return c.getCurrentWeather(map[string]string{
 		"lat":   fmt.Sprintf("%f", lat),
 		"lon":   fmt.Sprintf("%f", lon),
-		"units": c.units,
+		"units": string(c.units),
 	})
-	if err != nil {
-		return nil, err
-	}
-	return c.parseSuccessfulResponse(res.Body)
 }
 
 // GetWeatherByCityName returns the current weather at the given city name.
 func (c *APIClient) GetWeatherByCityName(cityName string) (*WeatherItem, error) {
-	res, err := c.makeHTTPCall(currentWeatherPath, map[string]string{
+	return c.getCurrentWeather(map[string]string{
 		"q":     cityName,
-		"units": c.units,
+		"units": string(c.units),
+	})
+}
+
+// getCurrentWeather performs a current weather call with the given query parameters.
+func (c *APIClient) getCurrentWeather(q map[string]string) (*WeatherItem, error) {
+	var item *WeatherItem
+	err := c.makeHTTPCall(currentWeatherPath, q, func(body io.Reader) error {
+		var err error
+		item, err = c.parseSuccessfulResponse(body)
+		return err
 	})
 	if err != nil {
 		return nil, err
 	}
-	return c.parseSuccessfulResponse(res.Body)
+	item.Endpoint, _ = c.endpoint(currentWeatherPath)
+	item.Attempts = 1
+	return item, nil
 }
 
 type currentWeatherResponse struct {
 	ObservationTime int                      `json:"dt"`
-	Coordinates     weatherResponseCoords    `json:"coord"`
+	Coordinates     *weatherResponseCoords   `json:"coord"`
 	Weather         []weatherResponseWeather `json:"weather"`
 	Data            *WeatherItem             `json:"main"`
+	Wind            weatherResponseWind      `json:"wind"`
 	CityName        string                   `json:"name"`
 }
 
+type weatherResponseWind struct {
+	Speed float64 `json:"speed"`
+}
+
 type weatherResponseCoords struct {
 	Lat float64 `json:"lat"`
 	Lon float64 `json:"lon"`
@@ -73,36 +149,52 @@ type weatherResponseCoords struct {
 
 type weatherResponseWeather struct {
 	Description string `json:"main"`
+	Details     string `json:"description"`
 }
 
-func (c *APIClient) parseSuccessfulResponse(content io.ReadCloser) (*WeatherItem, error) {
+func (c *APIClient) parseSuccessfulResponse(content io.Reader) (*WeatherItem, error) {
 	data := currentWeatherResponse{}
 	decoder := json.NewDecoder(content)
 	if err := decoder.Decode(&data); err != nil {
-		return nil, fmt.Errorf("failed parsing API response: %v", err)
+		return nil, &DecodeError{Err: err}
+	}
+	if err := data.validate(c.units); err != nil {
+		return nil, err
 	}
 	item := data.Data
 	item.Lat = data.Coordinates.Lat
 	item.Lon = data.Coordinates.Lon
 	item.CityName = data.CityName
 	item.ObservationTime = data.ObservationTime
+	item.WindSpeed = data.Wind.Speed
+	item.Units = c.units
 
+	item.Language = c.lang
 	item.Description = make([]string, len(data.Weather))
+	item.Details = make([]string, len(data.Weather))
 	for i, d := range data.Weather {
 		item.Description[i] = d.Description
+		item.Details[i] = d.Details
 	}
 	return item, nil
 }
 
-// makeHTTPCall performs an HTTP GET request to Open Weather's REST API using API access token.
-func (c *APIClient) makeHTTPCall(path string, q map[string]string) (*http.Response, error) {
-	base, err := url.Parse(c.apiURL)
+// makeHTTPCall performs an HTTP GET request to Open Weather's REST API using API access token and
+// decodes successful responses body using decode. The response body is always drained and closed.
+func (c *APIClient) makeHTTPCall(path string, q map[string]string, decode func(io.Reader) error) error {
+	endpoint, err := c.endpoint(path)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return err
 	}
-	base.Path += path
 	params := url.Values{}
 	q["appid"] = c.apiKey
+	if c.lang != "" {
+		q["lang"] = c.lang
+	}
 	for k, v := range q {
 		params.Add(k, v)
 	}
@@ -110,32 +202,44 @@ func (c *APIClient) makeHTTPCall(path string, q map[string]string) (*http.Respon
 
 	res, err := c.client.Get(base.String())
 	if err != nil {
-		return nil, err
+		// Errors returned by the HTTP client include the request URL, which holds the API key.
+		return c.redactError(err)
 	}
+	defer drainAndClose(res.Body)
 	if res.StatusCode != http.StatusOK {
-		return nil, handleError(res)
+		return c.handleError(res)
+	}
+	return decode(res.Body)
+}
+
+// endpoint returns the URL of the given API path, without query parameters.
+func (c *APIClient) endpoint(path string) (string, error) {
+	base, err := url.Parse(c.apiURL)
+	if err != nil {
+		return "", err
 	}
-	return res, nil
+	base.Path += path
+	return base.String(), nil
 }
 
 type apiError struct {
-	Code    string `json:"cod"`
 	Message string `json:"message"`
 }
 
-func handleError(res *http.Response) error {
+func (c *APIClient) handleError(res *http.Response) error {
 	apiError := apiError{}
-	decoder := json.NewDecoder(res.Body)
-	if err := decoder.Decode(&apiError); err != nil {
-		return fmt.Errorf("OpenWeather API returned unexpected error")
-	}
-	switch res.StatusCode {
-	case http.StatusNotFound:
-		return fmt.Errorf("resource not found: %q", apiError.Message)
-	case http.StatusTooManyRequests:
-		return fmt.Errorf("exceeded requests limit")
-	case http.StatusUnauthorized:
-		return fmt.Errorf("invalid API key")
-	}
-	return fmt.Errorf("unexpected error")
+	// Best effort, error bodies are not guaranteed to be JSON.
+	json.NewDecoder(res.Body).Decode(&apiError)
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		// The message may echo the request, API key included.
+		return fmt.Errorf("%w: %q", ErrNotFound, c.redact(apiError.Message))
+	case res.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case res.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case res.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: %s", ErrServer, res.Status)
+	}
+	return fmt.Errorf("unexpected error: %s", res.Status)
 }
@@ -1,12 +1,15 @@
 package openweather
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func newTestAPIClient(apiKey, units string, server *httptest.Server, malformedURL bool) API {
@@ -38,7 +41,10 @@ func compareResults(t *testing.T, caller string, gotRes, wantRes *WeatherItem, g
 	if gotErr != nil {
 		t.Fatalf("%s returned unexpected error: %v", caller, gotErr)
 	}
-	if diff := cmp.Diff(gotRes, wantRes); diff != "" {
+	if !strings.HasSuffix(gotRes.Endpoint, "/"+currentWeatherPath) || gotRes.Attempts != 1 {
+		t.Errorf("%s returned endpoint %q and %d attempts, want a %s endpoint and 1 attempt", caller, gotRes.Endpoint, gotRes.Attempts, currentWeatherPath)
+	}
+	if diff := cmp.Diff(gotRes, wantRes, cmpopts.IgnoreFields(WeatherItem{}, "Endpoint", "Attempts")); diff != "" {
 		t.Errorf("%s: %v, want %v\ngot -> want diff: %s", caller, gotRes, wantRes, diff)
 	}
 }
@@ -79,7 +85,7 @@ func TestNewAPIClient(t *testing.T) {
 			if !test.wantError && got.apiKey != test.apiKey {
 				t.Errorf("NewAPIClient(%s, %s) returned client with API key %s, want %s", test.apiKey, test.units, got.apiKey, test.apiKey)
 			}
-			if !test.wantError && got.units != test.units {
+			if !test.wantError && string(got.units) != test.units {
 				t.Errorf("NewAPIClient(%s, %s) returned client with units %s, want %s", test.apiKey, test.units, got.units, test.units)
 			}
 		})
@@ -96,6 +102,7 @@ func TestAPIClient_OWCurrent(t *testing.T) {
 		malformedURL  bool
 		wantRes       *WeatherItem
 		wantErr       bool
+		wantErrIs     error
 		closeServer   bool
 	}{
 		{
@@ -156,6 +163,7 @@ func TestAPIClient_OWCurrent(t *testing.T) {
 				Lat:             37.39,
 				Lon:             -122.08,
 				Description:     []string{"Smoke", "Haze"},
+				Details:         []string{"smoke", "haze"},
 				CityName:        "Mountain View",
 				ObservationTime: 1601662295,
 				Temp:            28.87,
@@ -163,6 +171,8 @@ func TestAPIClient_OWCurrent(t *testing.T) {
 				MinTemp:         27,
 				FeelsLike:       27.8,
 				Humidity:        30,
+				WindSpeed:       1.42,
+				Units:           Metric,
 			},
 		},
 		{
@@ -184,6 +194,7 @@ func TestAPIClient_OWCurrent(t *testing.T) {
 			}`),
 			apiStatusCode: http.StatusTooManyRequests,
 			wantErr:       true,
+			wantErrIs:     ErrRateLimited,
 		},
 		{
 			name: "invalid API key",
@@ -195,6 +206,7 @@ func TestAPIClient_OWCurrent(t *testing.T) {
 			}`),
 			apiStatusCode: http.StatusUnauthorized,
 			wantErr:       true,
+			wantErrIs:     ErrUnauthorized,
 		},
 		{
 			name: "unreachable service",
@@ -222,6 +234,16 @@ func TestAPIClient_OWCurrent(t *testing.T) {
 			}`),
 			apiStatusCode: http.StatusNotFound,
 			wantErr:       true,
+			wantErrIs:     ErrNotFound,
+		},
+		{
+			name: "server error",
+			lat:  1.0, lon: 2.0,
+			cityName:      "Mountain View",
+			apiRes:        []byte(`<html>Bad Gateway</html>`),
+			apiStatusCode: http.StatusBadGateway,
+			wantErr:       true,
+			wantErrIs:     ErrServer,
 		},
 	}
 	for _, test := range tests {
@@ -239,6 +261,78 @@ func TestAPIClient_OWCurrent(t *testing.T) {
 
 			nameRes, nameErr := client.GetWeatherByCityName(test.cityName)
 			compareResults(t, fmt.Sprintf("GetWeatherByCityName(%s)", test.cityName), nameRes, test.wantRes, nameErr, test.wantErr)
+
+			if test.wantErrIs != nil {
+				if !errors.Is(coordsErr, test.wantErrIs) {
+					t.Errorf("GetWeatherByCoords(%f, %f) returned error %v, want %v", test.lat, test.lon, coordsErr, test.wantErrIs)
+				}
+				if !errors.Is(nameErr, test.wantErrIs) {
+					t.Errorf("GetWeatherByCityName(%s) returned error %v, want %v", test.cityName, nameErr, test.wantErrIs)
+				}
+			}
+		})
+	}
+}
+
+func TestWithLanguage(t *testing.T) {
+	tests := []struct {
+		name      string
+		lang      string
+		wantLang  string
+		wantError bool
+	}{
+		{
+			name:     "supported language",
+			lang:     "es",
+			wantLang: "es",
+		},
+		{
+			name:     "mixed case language",
+			lang:     "ZH_CN",
+			wantLang: "zh_cn",
+		},
+		{
+			name:      "unsupported language",
+			lang:      "klingon",
+			wantError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gotQueryLang string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotQueryLang = r.URL.Query().Get("lang")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`{"coord": {"lat": 19.43, "lon": -99.13}, "dt": 1601662295, "main": {"temp": 20},
+					"name": "Ciudad de México", "weather": [{"main": "Rain", "description": "lluvia ligera"}]}`))
+			}))
+			defer server.Close()
+
+			c, err := NewAPIClient("apiKey", "metric", WithLanguage(test.lang))
+			if err != nil && !test.wantError {
+				t.Fatalf("NewAPIClient(apiKey, metric, WithLanguage(%s)) returned unexpected error: %v", test.lang, err)
+			}
+			if err == nil && test.wantError {
+				t.Fatalf("NewAPIClient(apiKey, metric, WithLanguage(%s)) returned nil error, want error", test.lang)
+			}
+			if test.wantError {
+				return
+			}
+			c.client, c.apiURL = server.Client(), server.URL
+
+			got, err := c.GetWeatherByCityName("Ciudad de México")
+			if err != nil {
+				t.Fatalf("GetWeatherByCityName(Ciudad de México) returned unexpected error: %v", err)
+			}
+			if gotQueryLang != test.wantLang {
+				t.Errorf("GetWeatherByCityName(Ciudad de México) sent lang=%q, want %q", gotQueryLang, test.wantLang)
+			}
+			if got.Language != test.wantLang {
+				t.Errorf("GetWeatherByCityName(Ciudad de México) returned language %q, want %q", got.Language, test.wantLang)
+			}
+			if diff := cmp.Diff(got.Details, []string{"lluvia ligera"}); diff != "" {
+				t.Errorf("GetWeatherByCityName(Ciudad de México) returned details %v, want [lluvia ligera]\ngot -> want diff: %s", got.Details, diff)
+			}
 		})
 	}
 }
@@ -0,0 +1,16 @@
+package openweather
+
+import "errors"
+
+// Errors returned by API implementations when OpenWeather rejects a call. Use errors.Is to check
+// for them since they may be wrapped with further details.
+var (
+	// ErrNotFound is returned when the requested location doesn't exist.
+	ErrNotFound = errors.New("resource not found")
+	// ErrRateLimited is returned when the API key exceeded its plan's requests limit.
+	ErrRateLimited = errors.New("exceeded requests limit")
+	// ErrUnauthorized is returned when the API key is invalid, revoked or not yet activated.
+	ErrUnauthorized = errors.New("invalid API key")
+	// ErrServer is returned when OpenWeather fails to process a valid call.
+	ErrServer = errors.New("OpenWeather API server error")
+)
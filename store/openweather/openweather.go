@@ -2,6 +2,9 @@
 // to Open Weather's REST API (https://openweathermap.org/api).
 package openweather
 
+// ProviderName identifies OpenWeather as the source of weather data.
+const ProviderName = "OpenWeather"
+
 // API is a https://openweathermap.org/api API client.
 type API interface {
 	// GetWeatherByCoords returns the current weather at the given location.
@@ -20,18 +23,31 @@ type WeatherItem struct {
 	Lon float64
 	// Description is a human readable set of weather descriptions
 	Description []string
+	// Details is the detailed description of each weather condition, e.g: "light rain". Unlike
+	// Description, it is localized to Language.
+	Details []string
+	// Language of Details, empty when the API default language (English) was used.
+	Language string
 	// CityName is the city name registered in the API dataset for the weather observation.
 	CityName string
 	// ObservationTime in UNIX time UTC
 	ObservationTime int
-	// Temp is the temperature in celsius.
+	// Units system of temperatures and wind speed.
+	Units Units
+	// Endpoint is the URL of the API endpoint the item was obtained from, without query parameters.
+	Endpoint string
+	// Attempts is the number of API calls performed to obtain the item, e.g: retries with other keys.
+	Attempts int
+	// Temp is the temperature in Units.
 	Temp float64 `json:"temp"`
 	// MaxTemp is the maximum expected temperature for the observation time.
 	MaxTemp float64 `json:"temp_max"`
 	// MinTemp is the maximum expected temperature for the observation time.
 	MinTemp float64 `json:"temp_min"`
-	// FeelsLike in celsius.
+	// FeelsLike in Units.
 	FeelsLike float64 `json:"feels_like"`
 	// Humidity percentage.
 	Humidity int `json:"humidity"`
+	// WindSpeed in Units, i.e: meter/sec for standard and metric, miles/hour for imperial.
+	WindSpeed float64
 }
@@ -0,0 +1,33 @@
+package openweather
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Redacted replaces secrets, e.g: API keys, in errors returned by the client.
+const Redacted = "REDACTED"
+
+// redactError returns err with any occurrence of the client API key replaced by Redacted. Errors
+// wrapping the request URL, such as *url.Error, are rebuilt so the key can't be recovered by
+// unwrapping them either.
+func (c *APIClient) redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return &url.Error{Op: urlErr.Op, URL: c.redact(urlErr.URL), Err: c.redactError(urlErr.Err)}
+	}
+	if msg := err.Error(); msg != c.redact(msg) {
+		return errors.New(c.redact(msg))
+	}
+	return err
+}
+
+// redact returns s with the client API key, in both its raw and URL-encoded forms, replaced by
+// Redacted.
+func (c *APIClient) redact(s string) string {
+	return strings.NewReplacer(c.apiKey, Redacted, url.QueryEscape(c.apiKey), Redacted).Replace(s)
+}
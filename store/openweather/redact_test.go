@@ -0,0 +1,106 @@
+package openweather
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// secretAPIKey contains characters escaped in URLs so both raw and encoded forms are checked.
+const secretAPIKey = "s3cr3t+k3y/42="
+
+// assertRedacted fails the test if the secret API key appears in err or any error it wraps.
+func assertRedacted(t *testing.T, caller string, err error) {
+	t.Helper()
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		for _, s := range []string{e.Error(), fmt.Sprintf("%+v", e), fmt.Sprintf("%#v", e)} {
+			if strings.Contains(s, secretAPIKey) || strings.Contains(s, url.QueryEscape(secretAPIKey)) {
+				t.Errorf("%s returned error exposing the API key: %s", caller, s)
+			}
+		}
+	}
+}
+
+func TestAPIClient_RedactsAPIKey(t *testing.T) {
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		closeServer   bool
+		clientTimeout time.Duration
+	}{
+		{
+			name:        "unreachable service",
+			handler:     func(w http.ResponseWriter, _ *http.Request) {},
+			closeServer: true,
+		},
+		{
+			name: "request timeout",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				time.Sleep(100 * time.Millisecond)
+			},
+			clientTimeout: 10 * time.Millisecond,
+		},
+		{
+			name: "redirect loop",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, r.URL.String(), http.StatusFound)
+			},
+		},
+		{
+			name: "invalid API key",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"cod": 401, "message": "Invalid API key ` + secretAPIKey + `"}`))
+			},
+		},
+		{
+			name: "not found message echoing the API key",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"cod": "404", "message": "nothing found for appid=` + secretAPIKey + `"}`))
+			},
+		},
+		{
+			name: "malformed response echoing the request",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.URL.RawQuery))
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(test.handler)
+			if test.closeServer {
+				server.Close()
+			} else {
+				defer server.Close()
+			}
+			c, err := NewAPIClient(secretAPIKey, "metric")
+			if err != nil {
+				t.Fatalf("NewAPIClient returned unexpected error: %v", err)
+			}
+			c.apiURL = server.URL
+			c.client = server.Client()
+			if test.clientTimeout > 0 {
+				c.client.Timeout = test.clientTimeout
+			}
+
+			_, err = c.GetWeatherByCoords(1, 2)
+			if err == nil {
+				t.Fatalf("GetWeatherByCoords(1, 2) returned nil error, want error")
+			}
+			assertRedacted(t, "GetWeatherByCoords(1, 2)", err)
+
+			_, err = c.GetWeatherByCityName("Mountain View")
+			if err == nil {
+				t.Fatalf("GetWeatherByCityName(Mountain View) returned nil error, want error")
+			}
+			assertRedacted(t, "GetWeatherByCityName(Mountain View)", err)
+		})
+	}
+}
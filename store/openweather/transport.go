@@ -0,0 +1,58 @@
+package openweather
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultMaxIdleConnsPerHost matches the default number of calls the store performs at the same
+	// time (store.DefaultConcurrency), so every worker can reuse an already established connection.
+	DefaultMaxIdleConnsPerHost = 10
+
+	requestTimeout = 30 * time.Second
+	// maxDrainBytes is the maximum number of unread body bytes discarded before closing a response.
+	// Bodies larger than this are closed right away and their connection is not reused.
+	maxDrainBytes = 64 << 10
+)
+
+// sharedTransport is used by all clients not configured with WithTransport.
+var sharedTransport = NewTransport(DefaultMaxIdleConnsPerHost)
+
+// NewTransport returns an HTTP transport tuned for performing many concurrent calls against the
+// same host, keeping up to maxIdleConnsPerHost connections alive between calls. Clients sharing
+// a transport share its connection pool.
+func NewTransport(maxIdleConnsPerHost int) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConnsPerHost,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// WithTransport makes the client perform HTTP calls using the given transport, e.g: one created
+// with NewTransport and sized to the caller's concurrency.
+func WithTransport(t http.RoundTripper) Option {
+	return func(c *APIClient) error {
+		c.client = &http.Client{Transport: t, Timeout: requestTimeout}
+		return nil
+	}
+}
+
+// drainAndClose discards what is left of body and closes it so the underlying connection can be
+// returned to the pool.
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
@@ -0,0 +1,128 @@
+package openweather
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const benchmarkPayload = `{"coord": {"lat": 19.43, "lon": -99.13}, "dt": 1601662295,
+	"main": {"temp": 20, "temp_max": 21, "temp_min": 19, "feels_like": 20, "humidity": 50},
+	"name": "Mexico City", "weather": [{"main": "Clouds", "description": "overcast clouds"}]}`
+
+// newCountingTLSServer returns a TLS server replying with the given status code and payload,
+// and a function returning the number of connections it has accepted so far.
+func newCountingTLSServer(statusCode int, payload string) (*httptest.Server, func() int64) {
+	var conns int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(statusCode)
+		w.Write([]byte(payload))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	server.StartTLS()
+	return server, func() int64 { return atomic.LoadInt64(&conns) }
+}
+
+// newTLSTestClient returns a client using a transport created with NewTransport that trusts the
+// test server certificate.
+func newTLSTestClient(t testing.TB, server *httptest.Server, maxIdleConnsPerHost int) *APIClient {
+	t.Helper()
+	transport := NewTransport(maxIdleConnsPerHost)
+	transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+	c, err := NewAPIClient("apiKey", "metric", WithTransport(transport))
+	if err != nil {
+		t.Fatalf("NewAPIClient(apiKey, metric) returned unexpected error: %v", err)
+	}
+	c.apiURL = server.URL
+	return c
+}
+
+func TestAPIClient_ConnectionReuse(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		payload    string
+	}{
+		{
+			name:       "successful responses",
+			statusCode: http.StatusOK,
+			payload:    benchmarkPayload,
+		},
+		{
+			name:       "error responses",
+			statusCode: http.StatusNotFound,
+			payload:    `{"cod": "404", "message": "city not found"}`,
+		},
+		{
+			name:       "undecodable responses",
+			statusCode: http.StatusOK,
+			payload:    `{"cod": 200, "main": null, "trailing": "` + string(make([]byte, 4096)) + `"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, conns := newCountingTLSServer(test.statusCode, test.payload)
+			defer server.Close()
+			c := newTLSTestClient(t, server, 1)
+
+			for i := 0; i < 20; i++ {
+				c.GetWeatherByCityName("Mexico City")
+			}
+			if got := conns(); got != 1 {
+				t.Errorf("20 sequential calls opened %d connections, want 1", got)
+			}
+		})
+	}
+}
+
+func TestAPIClient_ConcurrentConnectionReuse(t *testing.T) {
+	const workers, callsPerWorker = 4, 25
+	server, conns := newCountingTLSServer(http.StatusOK, benchmarkPayload)
+	defer server.Close()
+	c := newTLSTestClient(t, server, workers)
+	// Without a limit, a request issued before the previous connection is back in the idle pool
+	// would dial a new one.
+	c.client.Transport.(*http.Transport).MaxConnsPerHost = workers
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < callsPerWorker; i++ {
+				if _, err := c.GetWeatherByCityName("Mexico City"); err != nil {
+					t.Errorf("GetWeatherByCityName(Mexico City) returned unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := conns(); got > workers {
+		t.Errorf("%d calls from %d workers opened %d connections, want at most %d", workers*callsPerWorker, workers, got, workers)
+	}
+}
+
+// BenchmarkAPIClient_TLS measures calls against a TLS server and reports the number of
+// connections opened per call, which stays close to zero when connections are reused.
+func BenchmarkAPIClient_TLS(b *testing.B) {
+	server, conns := newCountingTLSServer(http.StatusOK, benchmarkPayload)
+	defer server.Close()
+	c := newTLSTestClient(b, server, DefaultMaxIdleConnsPerHost)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := c.GetWeatherByCityName("Mexico City"); err != nil {
+				b.Errorf("GetWeatherByCityName(Mexico City) returned unexpected error: %v", err)
+			}
+		}
+	})
+	b.ReportMetric(float64(conns())/float64(b.N), "conns/op")
+}
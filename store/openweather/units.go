@@ -0,0 +1,78 @@
+package openweather
+
+import "fmt"
+
+// Units is a units system supported by OpenWeather. See https://openweathermap.org/current#data.
+type Units string
+
+const (
+	// Standard units: temperature in Kelvin and wind speed in meter/sec.
+	Standard Units = "standard"
+	// Metric units: temperature in Celsius and wind speed in meter/sec.
+	Metric Units = "metric"
+	// Imperial units: temperature in Fahrenheit and wind speed in miles/hour.
+	Imperial Units = "imperial"
+)
+
+// metersPerSecondInMPH is the number of meters per second in one mile per hour.
+const metersPerSecondInMPH = 0.44704
+
+// ParseUnits returns the Units value represented by s.
+func ParseUnits(s string) (Units, error) {
+	switch u := Units(s); u {
+	case Standard, Metric, Imperial:
+		return u, nil
+	}
+	return "", fmt.Errorf("got invalid units value %s, want one of standard, metric, or imperial", s)
+}
+
+// TemperatureSymbol returns the temperature unit symbol, e.g: °C for metric units.
+func (u Units) TemperatureSymbol() string {
+	switch u {
+	case Standard:
+		return "K"
+	case Imperial:
+		return "°F"
+	}
+	return "°C"
+}
+
+// SpeedSymbol returns the speed unit symbol, e.g: m/s for metric units.
+func (u Units) SpeedSymbol() string {
+	if u == Imperial {
+		return "mph"
+	}
+	return "m/s"
+}
+
+// ConvertTemperature converts temperature t from units system from to units system to.
+func ConvertTemperature(t float64, from, to Units) float64 {
+	if from == to {
+		return t
+	}
+	// Normalize to Celsius first.
+	switch from {
+	case Standard:
+		t -= 273.15
+	case Imperial:
+		t = (t - 32) * 5 / 9
+	}
+	switch to {
+	case Standard:
+		return t + 273.15
+	case Imperial:
+		return t*9/5 + 32
+	}
+	return t
+}
+
+// ConvertSpeed converts speed v from units system from to units system to.
+func ConvertSpeed(v float64, from, to Units) float64 {
+	if from.SpeedSymbol() == to.SpeedSymbol() {
+		return v
+	}
+	if from == Imperial {
+		return v * metersPerSecondInMPH
+	}
+	return v / metersPerSecondInMPH
+}
@@ -0,0 +1,71 @@
+package openweather
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseUnits(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Units
+		wantErr bool
+	}{
+		{in: "standard", want: Standard},
+		{in: "metric", want: Metric},
+		{in: "imperial", want: Imperial},
+		{in: "american", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseUnits(test.in)
+		if err != nil && !test.wantErr {
+			t.Errorf("ParseUnits(%q) returned unexpected error: %v", test.in, err)
+		}
+		if err == nil && test.wantErr {
+			t.Errorf("ParseUnits(%q) returned nil error, want error", test.in)
+		}
+		if got != test.want {
+			t.Errorf("ParseUnits(%q): %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestConvertTemperature(t *testing.T) {
+	tests := []struct {
+		t        float64
+		from, to Units
+		want     float64
+	}{
+		{t: 20, from: Metric, to: Metric, want: 20},
+		{t: 0, from: Metric, to: Imperial, want: 32},
+		{t: 100, from: Metric, to: Standard, want: 373.15},
+		{t: 212, from: Imperial, to: Metric, want: 100},
+		{t: 32, from: Imperial, to: Standard, want: 273.15},
+		{t: 273.15, from: Standard, to: Metric, want: 0},
+		{t: 255.37222, from: Standard, to: Imperial, want: 0},
+	}
+	for _, test := range tests {
+		if got := ConvertTemperature(test.t, test.from, test.to); math.Abs(got-test.want) > 1e-3 {
+			t.Errorf("ConvertTemperature(%f, %s, %s): %f, want %f", test.t, test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestConvertSpeed(t *testing.T) {
+	tests := []struct {
+		v        float64
+		from, to Units
+		want     float64
+	}{
+		{v: 10, from: Metric, to: Standard, want: 10},
+		{v: 10, from: Imperial, to: Imperial, want: 10},
+		{v: 0.44704, from: Metric, to: Imperial, want: 1},
+		{v: 1, from: Imperial, to: Standard, want: 0.44704},
+	}
+	for _, test := range tests {
+		if got := ConvertSpeed(test.v, test.from, test.to); math.Abs(got-test.want) > 1e-6 {
+			t.Errorf("ConvertSpeed(%f, %s, %s): %f, want %f", test.v, test.from, test.to, got, test.want)
+		}
+	}
+}
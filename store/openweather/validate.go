@@ -0,0 +1,75 @@
+package openweather
+
+import "fmt"
+
+// Plausible temperature range in Kelvin. The lowest and highest temperatures ever recorded on Earth
+// are roughly 184K and 330K, anything far beyond them is considered a corrupted value.
+const (
+	minPlausibleTemp = 150.0
+	maxPlausibleTemp = 350.0
+)
+
+// DecodeError is returned when a successful API response can't be decoded or doesn't match the
+// expected schema.
+type DecodeError struct {
+	// Field is the JSON path of the offending field, e.g: main.humidity. It is empty when the
+	// response body is not valid JSON.
+	Field string
+	// Reason describes why the field was rejected.
+	Reason string
+	// Err is the underlying decoding error, if any.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("failed parsing API response: %v", e.Err)
+	}
+	return fmt.Sprintf("invalid API response: field %q %s", e.Field, e.Reason)
+}
+
+// Unwrap returns the underlying decoding error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// validate checks the response contains all required sections and its values are plausible. Units
+// is the units system temperatures are expressed in.
+func (r *currentWeatherResponse) validate(units Units) error {
+	if r.Data == nil {
+		return &DecodeError{Field: "main", Reason: "is missing"}
+	}
+	if r.Coordinates == nil {
+		return &DecodeError{Field: "coord", Reason: "is missing"}
+	}
+	if r.ObservationTime <= 0 {
+		return &DecodeError{Field: "dt", Reason: fmt.Sprintf("must be a positive UNIX time, got %d", r.ObservationTime)}
+	}
+	if lat := r.Coordinates.Lat; lat < -90 || lat > 90 {
+		return &DecodeError{Field: "coord.lat", Reason: fmt.Sprintf("must be within [-90, 90], got %f", lat)}
+	}
+	if lon := r.Coordinates.Lon; lon < -180 || lon > 180 {
+		return &DecodeError{Field: "coord.lon", Reason: fmt.Sprintf("must be within [-180, 180], got %f", lon)}
+	}
+	temps := []struct {
+		field string
+		value float64
+	}{
+		{"main.temp", r.Data.Temp},
+		{"main.temp_max", r.Data.MaxTemp},
+		{"main.temp_min", r.Data.MinTemp},
+		{"main.feels_like", r.Data.FeelsLike},
+	}
+	for _, t := range temps {
+		if k := ConvertTemperature(t.value, units, Standard); k < minPlausibleTemp || k > maxPlausibleTemp {
+			return &DecodeError{Field: t.field, Reason: fmt.Sprintf("has implausible value %0.2f%s", t.value, units.TemperatureSymbol())}
+		}
+	}
+	if h := r.Data.Humidity; h < 0 || h > 100 {
+		return &DecodeError{Field: "main.humidity", Reason: fmt.Sprintf("must be within [0, 100], got %d", h)}
+	}
+	if r.Wind.Speed < 0 {
+		return &DecodeError{Field: "wind.speed", Reason: fmt.Sprintf("must be non-negative, got %f", r.Wind.Speed)}
+	}
+	return nil
+}
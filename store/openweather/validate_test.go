@@ -0,0 +1,149 @@
+package openweather
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// goldenClient returns a client used to parse recorded payloads.
+func goldenClient(t *testing.T) *APIClient {
+	t.Helper()
+	c, err := NewAPIClient("apiKey", "metric")
+	if err != nil {
+		t.Fatalf("NewAPIClient(apiKey, metric) returned unexpected error: %v", err)
+	}
+	return c
+}
+
+func readPayload(t *testing.T, name string) []byte {
+	t.Helper()
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "current", name))
+	if err != nil {
+		t.Fatalf("failed reading payload %s: %v", name, err)
+	}
+	return content
+}
+
+func TestAPIClient_parseSuccessfulResponse_Golden(t *testing.T) {
+	tests := []struct {
+		payload   string
+		wantField string
+		wantErr   bool
+	}{
+		{payload: "mountain_view.json"},
+		{payload: "mexico_city.json"},
+		{payload: "no_weather_conditions.json"},
+		{payload: "missing_main.json", wantErr: true, wantField: "main"},
+		{payload: "missing_coord.json", wantErr: true, wantField: "coord"},
+		{payload: "zero_dt.json", wantErr: true, wantField: "dt"},
+		{payload: "invalid_latitude.json", wantErr: true, wantField: "coord.lat"},
+		{payload: "invalid_longitude.json", wantErr: true, wantField: "coord.lon"},
+		{payload: "invalid_humidity.json", wantErr: true, wantField: "main.humidity"},
+		{payload: "implausible_temperature.json", wantErr: true, wantField: "main.temp"},
+		{payload: "main_not_an_object.json", wantErr: true},
+		{payload: "truncated.json", wantErr: true},
+	}
+	c := goldenClient(t)
+	for _, test := range tests {
+		t.Run(test.payload, func(t *testing.T) {
+			content := readPayload(t, test.payload)
+			got, err := c.parseSuccessfulResponse(ioutil.NopCloser(bytes.NewReader(content)))
+			if test.wantErr {
+				var decodeErr *DecodeError
+				if !errors.As(err, &decodeErr) {
+					t.Fatalf("parseSuccessfulResponse(%s) returned error %v, want *DecodeError", test.payload, err)
+				}
+				if decodeErr.Field != test.wantField {
+					t.Errorf("parseSuccessfulResponse(%s) rejected field %q, want %q", test.payload, decodeErr.Field, test.wantField)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSuccessfulResponse(%s) returned unexpected error: %v", test.payload, err)
+			}
+
+			goldenPath := filepath.Join("testdata", "current", strings.TrimSuffix(test.payload, ".json")+".golden")
+			if *update {
+				content, _ := json.MarshalIndent(got, "", "  ")
+				if err := ioutil.WriteFile(goldenPath, append(content, '\n'), 0644); err != nil {
+					t.Fatalf("failed updating golden file %s: %v", goldenPath, err)
+				}
+			}
+			goldenContent, err := ioutil.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("failed reading golden file %s: %v", goldenPath, err)
+			}
+			want := &WeatherItem{}
+			if err := json.Unmarshal(goldenContent, want); err != nil {
+				t.Fatalf("failed decoding golden file %s: %v", goldenPath, err)
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("parseSuccessfulResponse(%s): %v, want %v\ngot -> want diff: %s", test.payload, got, want, diff)
+			}
+		})
+	}
+}
+
+// TestAPIClient_parseSuccessfulResponse_Fuzz feeds randomly mutated recorded payloads to the parser
+// and checks it never panics and only fails with a *DecodeError.
+func TestAPIClient_parseSuccessfulResponse_Fuzz(t *testing.T) {
+	payloads, err := filepath.Glob(filepath.Join("testdata", "current", "*.json"))
+	if err != nil || len(payloads) == 0 {
+		t.Fatalf("failed listing payloads: %v", err)
+	}
+	seeds := make([][]byte, len(payloads))
+	for i, p := range payloads {
+		seeds[i] = readPayload(t, filepath.Base(p))
+	}
+
+	c := goldenClient(t)
+	r := rand.New(rand.NewSource(1601662295))
+	for i := 0; i < 5000; i++ {
+		input := mutate(r, seeds[r.Intn(len(seeds))])
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					t.Fatalf("parseSuccessfulResponse(%q) panicked: %v", input, p)
+				}
+			}()
+			_, err := c.parseSuccessfulResponse(ioutil.NopCloser(bytes.NewReader(input)))
+			var decodeErr *DecodeError
+			if err != nil && !errors.As(err, &decodeErr) {
+				t.Errorf("parseSuccessfulResponse(%q) returned error %v, want *DecodeError", input, err)
+			}
+		}()
+	}
+}
+
+// mutate returns a copy of src with a random set of byte-level mutations applied.
+func mutate(r *rand.Rand, src []byte) []byte {
+	out := append([]byte(nil), src...)
+	tokens := [][]byte{[]byte("null"), []byte("{}"), []byte("[]"), []byte(`""`), []byte("-1e309"), []byte("99999999999")}
+	for n := r.Intn(4) + 1; n > 0 && len(out) > 0; n-- {
+		pos := r.Intn(len(out))
+		switch r.Intn(4) {
+		case 0: // Flip a byte.
+			out[pos] = byte(r.Intn(256))
+		case 1: // Truncate.
+			out = out[:pos]
+		case 2: // Delete a range.
+			end := pos + r.Intn(len(out)-pos+1)
+			out = append(out[:pos], out[end:]...)
+		case 3: // Insert a JSON token.
+			tok := tokens[r.Intn(len(tokens))]
+			out = append(out[:pos], append(append([]byte(nil), tok...), out[pos:]...)...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package store
+
+import "time"
+
+// estimatedBatchLatency is the expected time it takes to complete the calls allowed in one minute.
+const estimatedBatchLatency = time.Second
+
+// Plan describes the work required to answer a set of queries, without performing it.
+type Plan struct {
+	// Queries is the number of queries, including duplicates.
+	Queries int
+	// Unique is the number of distinct queries after deduplication.
+	Unique int
+	// Cached is the number of distinct queries that can be answered without calling the API, e.g:
+	// completed by previous runs according to the store journal or found in a CachedStore.
+	Cached int
+	// Calls is the number of API calls required.
+	Calls int
+	// Batches is the number of one minute windows calls are spread over to comply with the rate limit.
+	Batches int
+	// RequestsPerMinute is the rate limit the estimation is based on.
+	RequestsPerMinute int
+	// EstimatedDuration is the expected wall-clock time it takes to perform all calls.
+	EstimatedDuration time.Duration
+}
+
+// Planner is implemented by stores that can estimate the work required to answer queries without
+// calling the API.
+type Planner interface {
+	// PlanWeatherByAirportCode returns the plan for answering GetWeatherByAirportCode.
+	PlanWeatherByAirportCode([]Airport) Plan
+
+	// PlanWeatherByCityName returns the plan for answering GetWeatherByCityName.
+	PlanWeatherByCityName([]string) Plan
+}
+
+// PlanWeatherByAirportCode returns the plan for answering GetWeatherByAirportCode.
+func (s *ConcurrentStore) PlanWeatherByAirportCode(airports []Airport) Plan {
+	return s.plan(airportCodes(airports))
+}
+
+// PlanWeatherByCityName returns the plan for answering GetWeatherByCityName.
+func (s *ConcurrentStore) PlanWeatherByCityName(cities []string) Plan {
+	return s.plan(cities)
+}
+
+// plan returns the plan for fetching the given query keys.
+func (s *ConcurrentStore) plan(keys []string) Plan {
+	unique := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		unique[k] = true
+	}
+	// The current adaptive rate, which may be lower than the configured one after running into errors.
+	p := Plan{Queries: len(keys), Unique: len(unique), RequestsPerMinute: s.rate.limit()}
+	if s.journal != nil {
+		for k := range s.journal.Completed() {
+			if unique[k] {
+				p.Cached++
+			}
+		}
+	}
+	p.Calls = p.Unique - p.Cached
+	p.Batches = (p.Calls + p.RequestsPerMinute - 1) / p.RequestsPerMinute
+	if p.Batches > 0 {
+		// Calls of the last window start a minute after the ones from the previous window.
+		p.EstimatedDuration = time.Duration(p.Batches-1)*time.Minute + estimatedBatchLatency
+	}
+	return p
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+func TestConcurrentStore_PlanWeatherByAirportCode(t *testing.T) {
+	tests := []struct {
+		name              string
+		queries           []Airport
+		requestsPerMinute int
+		want              Plan
+	}{
+		{
+			name:              "empty airport list",
+			queries:           []Airport{},
+			requestsPerMinute: 60,
+			want:              Plan{RequestsPerMinute: 60},
+		},
+		{
+			name:              "repeated airports",
+			queries:           []Airport{airports["TLC"], airports["MTY"], airports["TLC"], airports["MEX"], airports["MTY"]},
+			requestsPerMinute: 60,
+			want:              Plan{Queries: 5, Unique: 3, Calls: 3, Batches: 1, RequestsPerMinute: 60, EstimatedDuration: time.Second},
+		},
+		{
+			name:              "multiple batches",
+			queries:           []Airport{airports["TLC"], airports["MTY"], airports["MEX"], airports["TAM"]},
+			requestsPerMinute: 3,
+			want:              Plan{Queries: 4, Unique: 4, Calls: 4, Batches: 2, RequestsPerMinute: 3, EstimatedDuration: time.Minute + time.Second},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := openweather.NewAPIMockClient(fixedWeatherResponse)
+			api.FailNext = true // Planning must not perform any call.
+			s := NewConcurrentStore(api, WithRequestsPerMinute(test.requestsPerMinute)).(Planner)
+			got := s.PlanWeatherByAirportCode(test.queries)
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("PlanWeatherByAirportCode(%v): %v, want %v\ndiff: got->want %s", test.queries, got, test.want, diff)
+			}
+		})
+	}
+}
+
+func TestConcurrentStore_PlanWeatherByCityName(t *testing.T) {
+	s := NewConcurrentStore(openweather.NewAPIMockClient(fixedWeatherResponse)).(Planner)
+	got := s.PlanWeatherByCityName([]string{"Denver", "Houston", "Denver"})
+	want := Plan{Queries: 3, Unique: 2, Calls: 2, Batches: 1, RequestsPerMinute: 60, EstimatedDuration: time.Second}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("PlanWeatherByCityName(): %v, want %v\ndiff: got->want %s", got, want, diff)
+	}
+}
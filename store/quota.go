@@ -0,0 +1,405 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+// quotaSaveInterval is how often calls counters are persisted while calls are performed.
+const quotaSaveInterval = 10 * time.Second
+
+// quotaLockTimeout is how long saving counters waits for other processes to release the quota
+// file, after which their lock is considered abandoned.
+const quotaLockTimeout = 5 * time.Second
+
+// ErrQuotaExceeded is returned when performing a call would exceed an API key budget.
+var ErrQuotaExceeded = errors.New("API key quota exceeded")
+
+// Budget is the maximum number of calls a single API key can perform on each period. Zero means
+// unlimited.
+type Budget struct {
+	PerMinute uint
+	PerDay    uint
+	PerMonth  uint
+}
+
+// Plans maps OpenWeather subscription plans to their budget. See https://openweathermap.org/price.
+var Plans = map[string]Budget{
+	"free":         {PerMinute: 60, PerMonth: 1000000},
+	"startup":      {PerMinute: 600, PerMonth: 10000000},
+	"developer":    {PerMinute: 3000, PerMonth: 100000000},
+	"professional": {PerMinute: 30000, PerMonth: 1000000000},
+}
+
+// QuotaUsage contains the calls performed by a single API key on the current periods.
+type QuotaUsage struct {
+	// KeyID identifies the API key without revealing it.
+	KeyID       string
+	MinuteCalls uint
+	DayCalls    uint
+	MonthCalls  uint
+}
+
+// Remaining returns the number of calls the key can still perform today given budget b, that is,
+// the minimum of its remaining daily and monthly budget. It returns false if both are unlimited.
+func (u QuotaUsage) Remaining(b Budget) (uint, bool) {
+	var remaining uint
+	limited := false
+	for _, p := range []struct{ budget, used uint }{{b.PerDay, u.DayCalls}, {b.PerMonth, u.MonthCalls}} {
+		if p.budget == 0 {
+			continue
+		}
+		left := uint(0)
+		if p.used < p.budget {
+			left = p.budget - p.used
+		}
+		if !limited || left < remaining {
+			remaining = left
+		}
+		limited = true
+	}
+	return remaining, limited
+}
+
+// quotaCounters are the persisted calls counters of a single API key. Each counter belongs to the
+// period it is labeled with and is reset once the period changes.
+type quotaCounters struct {
+	Minute      string `json:"minute"`
+	MinuteCalls uint   `json:"minute_calls"`
+	Day         string `json:"day"`
+	DayCalls    uint   `json:"day_calls"`
+	Month       string `json:"month"`
+	MonthCalls  uint   `json:"month_calls"`
+}
+
+// roll resets counters whose period is over at time t.
+func (c *quotaCounters) roll(t time.Time) {
+	t = t.UTC()
+	if m := t.Format("2006-01-02T15:04"); c.Minute != m {
+		c.Minute, c.MinuteCalls = m, 0
+	}
+	if d := t.Format("2006-01-02"); c.Day != d {
+		c.Day, c.DayCalls = d, 0
+	}
+	if m := t.Format("2006-01"); c.Month != m {
+		c.Month, c.MonthCalls = m, 0
+	}
+}
+
+// merge adds the calls of d to c, for the periods both are labeled with.
+func (c *quotaCounters) merge(d *quotaCounters) {
+	if c.Minute == d.Minute {
+		c.MinuteCalls += d.MinuteCalls
+	}
+	if c.Day == d.Day {
+		c.DayCalls += d.DayCalls
+	}
+	if c.Month == d.Month {
+		c.MonthCalls += d.MonthCalls
+	}
+}
+
+// Quota keeps track of the calls performed with each API key in a local file, so budgets are
+// enforced across program executions, including concurrent ones sharing the file. Periods are
+// computed in UTC.
+//
+// Counters are persisted every quotaSaveInterval and on Close, merging the calls performed since
+// the last save with the ones other processes saved meanwhile.
+type Quota struct {
+	mu     sync.Mutex
+	clock  clock
+	path   string
+	budget Budget
+	// counters by key ID, including the calls not saved yet.
+	counters map[string]*quotaCounters
+	// unsaved are the calls performed since counters were last saved, by key ID.
+	unsaved map[string]*quotaCounters
+	// saved is when counters were last saved.
+	saved time.Time
+	// saving is set while counters are being saved.
+	saving int32
+	// saveErr is the last error saving counters, if they weren't saved since.
+	saveErr error
+}
+
+// OpenQuota returns a Quota enforcing budget b and persisting calls counters at path. Counters
+// previously stored at path are loaded.
+func OpenQuota(path string, b Budget) (*Quota, error) {
+	return openQuota(realClock{}, path, b)
+}
+
+func openQuota(c clock, path string, b Budget) (*Quota, error) {
+	q := &Quota{clock: c, path: path, budget: b, unsaved: make(map[string]*quotaCounters), saved: c.Now()}
+	counters, err := readQuotaCounters(path)
+	if err != nil {
+		return nil, err
+	}
+	q.counters = counters
+	return q, nil
+}
+
+// readQuotaCounters returns the counters persisted at path, none if there's no file.
+func readQuotaCounters(path string) (map[string]*quotaCounters, error) {
+	counters := make(map[string]*quotaCounters)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return counters, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading quota file: %v", err)
+	}
+	if err := json.Unmarshal(content, &counters); err != nil {
+		return nil, fmt.Errorf("failed parsing quota file %s: %v", path, err)
+	}
+	return counters, nil
+}
+
+// Budget returns the budget enforced for each key.
+func (q *Quota) Budget() Budget {
+	return q.budget
+}
+
+// Usage returns the calls performed by the given key on the current periods.
+func (q *Quota) Usage(keyID string) QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	c := q.countersFor(keyID)
+	return QuotaUsage{KeyID: keyID, MinuteCalls: c.MinuteCalls, DayCalls: c.DayCalls, MonthCalls: c.MonthCalls}
+}
+
+// KeyIDs returns the IDs of every key with recorded calls, sorted.
+func (q *Quota) KeyIDs() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := make([]string, 0, len(q.counters))
+	for id := range q.counters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Wrap returns an API client that performs calls with api only while they fit in the budget. Calls
+// exceeding the per-minute budget are deferred until the next minute, calls exceeding the daily or
+// monthly budget are refused with ErrQuotaExceeded.
+func (q *Quota) Wrap(api KeyedAPI) KeyedAPI {
+	return &quotaAPI{quota: q, api: api}
+}
+
+// reserve records a call for the given key and returns zero if it fits in the budget. If the
+// minute budget is exhausted, no call is recorded and the time until the next minute is returned.
+func (q *Quota) reserve(keyID string) (time.Duration, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock.Now()
+	c := q.countersFor(keyID)
+	if q.budget.PerMonth > 0 && c.MonthCalls >= q.budget.PerMonth {
+		return 0, fmt.Errorf("%w: monthly budget of %d calls exhausted for key %s", ErrQuotaExceeded, q.budget.PerMonth, keyID)
+	}
+	if q.budget.PerDay > 0 && c.DayCalls >= q.budget.PerDay {
+		return 0, fmt.Errorf("%w: daily budget of %d calls exhausted for key %s", ErrQuotaExceeded, q.budget.PerDay, keyID)
+	}
+	if q.budget.PerMinute > 0 && c.MinuteCalls >= q.budget.PerMinute {
+		return now.Truncate(time.Minute).Add(time.Minute).Sub(now), nil
+	}
+	c.MinuteCalls++
+	c.DayCalls++
+	c.MonthCalls++
+	u, ok := q.unsaved[keyID]
+	if !ok {
+		u = &quotaCounters{}
+		q.unsaved[keyID] = u
+	}
+	u.roll(now)
+	u.MinuteCalls++
+	u.DayCalls++
+	u.MonthCalls++
+	return 0, nil
+}
+
+// countersFor returns the up to date counters of the given key. q.mu must be held.
+func (q *Quota) countersFor(keyID string) *quotaCounters {
+	c, ok := q.counters[keyID]
+	if !ok {
+		c = &quotaCounters{}
+		q.counters[keyID] = c
+	}
+	c.roll(q.clock.Now())
+	return c
+}
+
+// Close saves the calls performed since counters were last saved. Calls aren't failed when saving
+// them does, errors are reported here instead.
+func (q *Quota) Close() error {
+	// Wait for periodic saves in progress.
+	for !atomic.CompareAndSwapInt32(&q.saving, 0, 1) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer atomic.StoreInt32(&q.saving, 0)
+	q.save()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.saveErr
+}
+
+// maybeSave saves counters if they weren't saved for quotaSaveInterval, unless another call is
+// already saving them. Errors are kept for Close, calls go on with the counters in memory.
+func (q *Quota) maybeSave() {
+	q.mu.Lock()
+	due := q.clock.Now().Sub(q.saved) >= quotaSaveInterval
+	q.mu.Unlock()
+	if !due || !atomic.CompareAndSwapInt32(&q.saving, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&q.saving, 0)
+	q.save()
+}
+
+// save merges the calls performed since counters were last saved with the ones persisted at the
+// quota file, by this or other processes, and replaces the file atomically. The file is locked
+// meanwhile. Only one save runs at a time, see q.saving.
+func (q *Quota) save() {
+	q.mu.Lock()
+	unsaved := q.unsaved
+	q.unsaved = make(map[string]*quotaCounters)
+	q.saved = q.clock.Now()
+	q.mu.Unlock()
+
+	counters, err := q.mergeFile(unsaved)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil {
+		// Saved along with the next calls.
+		for id, u := range unsaved {
+			if pending, ok := q.unsaved[id]; ok {
+				u.roll(q.clock.Now())
+				u.merge(pending)
+			}
+			q.unsaved[id] = u
+		}
+		q.saveErr = err
+		return
+	}
+	q.saveErr = nil
+	// Take up the calls of other processes, along with the ones performed while saving.
+	for id, c := range counters {
+		c.roll(q.clock.Now())
+		if pending, ok := q.unsaved[id]; ok {
+			c.merge(pending)
+		}
+		q.counters[id] = c
+	}
+}
+
+// mergeFile adds unsaved calls to the counters persisted at the quota file and returns the result.
+func (q *Quota) mergeFile(unsaved map[string]*quotaCounters) (map[string]*quotaCounters, error) {
+	if err := os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		return nil, fmt.Errorf("failed saving quota file: %v", err)
+	}
+	unlock, err := lockFile(q.path + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("failed saving quota file: %v", err)
+	}
+	defer unlock()
+
+	counters, err := readQuotaCounters(q.path)
+	if err != nil {
+		return nil, err
+	}
+	now := q.clock.Now()
+	for id, u := range unsaved {
+		c, ok := counters[id]
+		if !ok {
+			c = &quotaCounters{}
+			counters[id] = c
+		}
+		c.roll(now)
+		u.roll(now)
+		c.merge(u)
+	}
+	content, err := json.MarshalIndent(counters, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	tmp := q.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return nil, fmt.Errorf("failed saving quota file: %v", err)
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return nil, fmt.Errorf("failed saving quota file: %v", err)
+	}
+	return counters, nil
+}
+
+// lockFile creates the lock file at path, waiting for other processes holding it to remove it. Locks
+// older than quotaLockTimeout are considered abandoned, e.g: by killed processes, and taken over.
+// It returns a function that releases the lock.
+func lockFile(path string) (func(), error) {
+	deadline := time.Now().Add(quotaLockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, statErr := os.Stat(path); statErr == nil && time.Since(info.ModTime()) > quotaLockTimeout {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// quotaAPI is a KeyedAPI whose calls are accounted for in a Quota.
+type quotaAPI struct {
+	quota *Quota
+	api   KeyedAPI
+}
+
+func (a *quotaAPI) KeyID() string {
+	return a.api.KeyID()
+}
+
+func (a *quotaAPI) GetWeatherByCoords(lat, lon float64) (*openweather.WeatherItem, error) {
+	return a.call(func() (*openweather.WeatherItem, error) {
+		return a.api.GetWeatherByCoords(lat, lon)
+	})
+}
+
+func (a *quotaAPI) GetWeatherByCityName(cityName string) (*openweather.WeatherItem, error) {
+	return a.call(func() (*openweather.WeatherItem, error) {
+		return a.api.GetWeatherByCityName(cityName)
+	})
+}
+
+// call performs f once the quota allows it.
+func (a *quotaAPI) call(f func() (*openweather.WeatherItem, error)) (*openweather.WeatherItem, error) {
+	for {
+		wait, err := a.quota.reserve(a.api.KeyID())
+		if err != nil {
+			return nil, err
+		}
+		if wait == 0 {
+			a.quota.maybeSave()
+			return f()
+		}
+		<-a.quota.clock.After(wait)
+	}
+}
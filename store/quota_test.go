@@ -0,0 +1,249 @@
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// tempDir returns a temporary directory removed once the test finishes.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "store-test")
+	if err != nil {
+		t.Fatalf("failed creating temporary directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func newTestQuota(t *testing.T, c clock, b Budget) (*Quota, string) {
+	t.Helper()
+	path := filepath.Join(tempDir(t), "quota", "quota.json")
+	q, err := openQuota(c, path, b)
+	if err != nil {
+		t.Fatalf("openQuota(%s) returned unexpected error: %v", path, err)
+	}
+	return q, path
+}
+
+func TestQuota_RefusesCallsOverBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget Budget
+		calls  int
+		// wantSuccess is the number of calls expected to succeed.
+		wantSuccess int
+	}{
+		{name: "unlimited", budget: Budget{}, calls: 10, wantSuccess: 10},
+		{name: "daily budget", budget: Budget{PerDay: 3, PerMonth: 100}, calls: 5, wantSuccess: 3},
+		{name: "monthly budget", budget: Budget{PerDay: 100, PerMonth: 4}, calls: 5, wantSuccess: 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, _ := newTestQuota(t, newFakeClock(), test.budget)
+			api := &fakeKeyedAPI{id: "a"}
+			wrapped := q.Wrap(api)
+			success := 0
+			for i := 0; i < test.calls; i++ {
+				_, err := wrapped.GetWeatherByCityName("Toluca")
+				if err == nil {
+					success++
+					continue
+				}
+				if !errors.Is(err, ErrQuotaExceeded) {
+					t.Fatalf("GetWeatherByCityName(Toluca) returned error %v, want %v", err, ErrQuotaExceeded)
+				}
+			}
+			if success != test.wantSuccess {
+				t.Errorf("%d calls: %d succeeded, want %d", test.calls, success, test.wantSuccess)
+			}
+			if api.calls != test.wantSuccess {
+				t.Errorf("%d calls reached the API, want %d", api.calls, test.wantSuccess)
+			}
+		})
+	}
+}
+
+func TestQuota_DefersCallsOverMinuteBudget(t *testing.T) {
+	c := newFakeClock()
+	q, _ := newTestQuota(t, c, Budget{PerMinute: 2})
+	wrapped := q.Wrap(&fakeKeyedAPI{id: "a"})
+
+	start := c.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := wrapped.GetWeatherByCoords(1, 2); err != nil {
+			t.Fatalf("GetWeatherByCoords(1, 2) returned unexpected error: %v", err)
+		}
+	}
+	// Calls 1-2 on the first minute, 3-4 on the second and 5 on the third. The fake clock starts
+	// 35 seconds into a minute.
+	if elapsed, want := c.Now().Sub(start), 2*time.Minute-35*time.Second; elapsed != want {
+		t.Errorf("5 calls took %s, want %s", elapsed, want)
+	}
+}
+
+func TestQuota_Persistence(t *testing.T) {
+	c := newFakeClock()
+	b := Budget{PerDay: 10}
+	q, path := newTestQuota(t, c, b)
+	for i := 0; i < 3; i++ {
+		q.Wrap(&fakeKeyedAPI{id: "a"}).GetWeatherByCityName("Toluca")
+	}
+	q.Wrap(&fakeKeyedAPI{id: "b"}).GetWeatherByCityName("Toluca")
+	if err := q.Close(); err != nil {
+		t.Fatalf("Close() returned unexpected error: %v", err)
+	}
+
+	reopened, err := openQuota(c, path, b)
+	if err != nil {
+		t.Fatalf("openQuota(%s) returned unexpected error: %v", path, err)
+	}
+	if diff := cmp.Diff(reopened.KeyIDs(), []string{"a", "b"}); diff != "" {
+		t.Errorf("KeyIDs(): %v, want [a b]\ndiff: got->want %s", reopened.KeyIDs(), diff)
+	}
+	want := QuotaUsage{KeyID: "a", MinuteCalls: 3, DayCalls: 3, MonthCalls: 3}
+	if diff := cmp.Diff(reopened.Usage("a"), want); diff != "" {
+		t.Errorf("Usage(a): %v, want %v\ndiff: got->want %s", reopened.Usage("a"), want, diff)
+	}
+
+	// Counters are reset once their period is over.
+	c.Advance(time.Hour)
+	want = QuotaUsage{KeyID: "a", DayCalls: 3, MonthCalls: 3}
+	if diff := cmp.Diff(reopened.Usage("a"), want); diff != "" {
+		t.Errorf("Usage(a) an hour later: %v, want %v\ndiff: got->want %s", reopened.Usage("a"), want, diff)
+	}
+	// The fake clock starts on September 30th, so a day later is a new month too.
+	c.Advance(24 * time.Hour)
+	want = QuotaUsage{KeyID: "a"}
+	if diff := cmp.Diff(reopened.Usage("a"), want); diff != "" {
+		t.Errorf("Usage(a) a day later: %v, want %v\ndiff: got->want %s", reopened.Usage("a"), want, diff)
+	}
+}
+
+func TestQuota_SavesPeriodically(t *testing.T) {
+	c := newFakeClock()
+	q, path := newTestQuota(t, c, Budget{})
+	wrapped := q.Wrap(&fakeKeyedAPI{id: "a"})
+	wrapped.GetWeatherByCityName("Toluca")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("quota file saved after a single call, want it saved every %s", quotaSaveInterval)
+	}
+
+	c.Advance(quotaSaveInterval)
+	wrapped.GetWeatherByCityName("Toluca")
+	reopened, err := openQuota(c, path, Budget{})
+	if err != nil {
+		t.Fatalf("openQuota(%s) returned unexpected error: %v", path, err)
+	}
+	if got := reopened.Usage("a").DayCalls; got != 2 {
+		t.Errorf("saved %d calls, want 2", got)
+	}
+}
+
+func TestQuota_SaveErrorsDontFailCalls(t *testing.T) {
+	c := newFakeClock()
+	q, path := newTestQuota(t, c, Budget{PerDay: 10})
+	// The quota directory can't be created where a file already is.
+	if err := ioutil.WriteFile(filepath.Dir(path), nil, 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile returned unexpected error: %v", err)
+	}
+	wrapped := q.Wrap(&fakeKeyedAPI{id: "a"})
+	for i := 0; i < 3; i++ {
+		c.Advance(quotaSaveInterval)
+		if _, err := wrapped.GetWeatherByCityName("Toluca"); err != nil {
+			t.Fatalf("GetWeatherByCityName(Toluca) returned unexpected error: %v", err)
+		}
+	}
+	if got := q.Usage("a").DayCalls; got != 3 {
+		t.Errorf("counted %d calls, want 3", got)
+	}
+	if err := q.Close(); err == nil {
+		t.Errorf("Close() returned no error, want the error saving the quota file")
+	}
+}
+
+func TestQuota_SharedAcrossProcesses(t *testing.T) {
+	c := newFakeClock()
+	b := Budget{PerDay: 5}
+	first, path := newTestQuota(t, c, b)
+	second, err := openQuota(c, path, b)
+	if err != nil {
+		t.Fatalf("openQuota(%s) returned unexpected error: %v", path, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		first.Wrap(&fakeKeyedAPI{id: "a"}).GetWeatherByCityName("Toluca")
+	}
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close() returned unexpected error: %v", err)
+	}
+	second.Wrap(&fakeKeyedAPI{id: "a"}).GetWeatherByCityName("Toluca")
+	c.Advance(quotaSaveInterval)
+	// Saving takes up the calls of the first process, leaving a single call of the daily budget.
+	second.Wrap(&fakeKeyedAPI{id: "a"}).GetWeatherByCityName("Toluca")
+	if got, want := second.Usage("a").DayCalls, uint(5); got != want {
+		t.Errorf("second process counts %d calls, want %d", got, want)
+	}
+	if _, err := second.Wrap(&fakeKeyedAPI{id: "a"}).GetWeatherByCityName("Toluca"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("GetWeatherByCityName(Toluca) over the shared budget returned error %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := second.Close(); err != nil {
+		t.Fatalf("Close() returned unexpected error: %v", err)
+	}
+
+	reopened, err := openQuota(c, path, b)
+	if err != nil {
+		t.Fatalf("openQuota(%s) returned unexpected error: %v", path, err)
+	}
+	if got, want := reopened.Usage("a").DayCalls, uint(5); got != want {
+		t.Errorf("saved %d calls, want %d", got, want)
+	}
+}
+
+func TestQuotaUsage_Remaining(t *testing.T) {
+	tests := []struct {
+		name        string
+		usage       QuotaUsage
+		budget      Budget
+		want        uint
+		wantLimited bool
+	}{
+		{name: "unlimited", usage: QuotaUsage{DayCalls: 5}, budget: Budget{PerMinute: 60}},
+		{name: "daily budget", usage: QuotaUsage{DayCalls: 5, MonthCalls: 5}, budget: Budget{PerDay: 10}, want: 5, wantLimited: true},
+		{name: "monthly budget is lower", usage: QuotaUsage{DayCalls: 5, MonthCalls: 95}, budget: Budget{PerDay: 10, PerMonth: 97}, want: 2, wantLimited: true},
+		{name: "exhausted", usage: QuotaUsage{DayCalls: 12}, budget: Budget{PerDay: 10}, want: 0, wantLimited: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, limited := test.usage.Remaining(test.budget)
+			if got != test.want || limited != test.wantLimited {
+				t.Errorf("Remaining(%v): (%d, %t), want (%d, %t)", test.budget, got, limited, test.want, test.wantLimited)
+			}
+		})
+	}
+}
+
+func TestKeyPool_RotatesAwayFromExhaustedKeys(t *testing.T) {
+	c := newFakeClock()
+	q, _ := newTestQuota(t, c, Budget{PerDay: 1})
+	p, _ := newKeyPool(c, 60, q.Wrap(&fakeKeyedAPI{id: "a"}), q.Wrap(&fakeKeyedAPI{id: "b"}))
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.GetWeatherByCityName("Toluca"); err != nil {
+			t.Fatalf("GetWeatherByCityName(Toluca) call %d returned unexpected error: %v", i+1, err)
+		}
+	}
+	if _, err := p.GetWeatherByCityName("Toluca"); !errors.Is(err, ErrNoUsableKeys) {
+		t.Errorf("GetWeatherByCityName(Toluca) with exhausted keys returned error %v, want %v", err, ErrNoUsableKeys)
+	}
+	want := []KeyUsage{{KeyID: "a", SuccessfulCalls: 1, Disabled: true}, {KeyID: "b", SuccessfulCalls: 1, Disabled: true}}
+	if diff := cmp.Diff(p.KeyUsage(), want); diff != "" {
+		t.Errorf("KeyUsage(): %v, want %v\ndiff: got->want %s", p.KeyUsage(), want, diff)
+	}
+}
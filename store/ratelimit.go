@@ -0,0 +1,69 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows up to limit calls within any sliding window of the given interval, e.g: 60
+// calls per minute.
+type rateLimiter struct {
+	mu       sync.Mutex
+	clock    clock
+	limit    int
+	interval time.Duration
+	// calls holds the start time of the most recent calls, oldest first.
+	calls []time.Time
+	// blockedUntil rejects every call until the given time, e.g: after the API rate-limited us.
+	blockedUntil time.Time
+}
+
+func newRateLimiter(c clock, limit int, interval time.Duration) *rateLimiter {
+	return &rateLimiter{clock: c, limit: limit, interval: interval, calls: make([]time.Time, 0, limit)}
+}
+
+// reserve records a call and returns zero if it is allowed right now. Otherwise no call is
+// recorded and the time to wait before trying again is returned.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	if now.Before(l.blockedUntil) {
+		return l.blockedUntil.Sub(now)
+	}
+	// Forget calls that are out of the window.
+	i := 0
+	for i < len(l.calls) && !now.Before(l.calls[i].Add(l.interval)) {
+		i++
+	}
+	l.calls = l.calls[i:]
+	if len(l.calls) >= l.limit {
+		return l.calls[0].Add(l.interval).Sub(now)
+	}
+	l.calls = append(l.calls, now)
+	return 0
+}
+
+// block rejects every call for the next interval.
+func (l *rateLimiter) block() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.blockedUntil = l.clock.Now().Add(l.interval)
+}
+
+// setLimit changes the number of calls allowed within the interval. Calls already in the window
+// count towards the new limit.
+func (l *rateLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+}
+
+// unreserve forgets the most recent call, e.g: a call that was short-circuited before reaching the API.
+func (l *rateLimiter) unreserve() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.calls) > 0 {
+		l.calls = l.calls[:len(l.calls)-1]
+	}
+}
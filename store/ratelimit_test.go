@@ -0,0 +1,55 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_reserve(t *testing.T) {
+	c := newFakeClock()
+	l := newRateLimiter(c, 3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if wait := l.reserve(); wait != 0 {
+			t.Fatalf("reserve() call %d returned wait %s, want 0", i+1, wait)
+		}
+		c.Advance(10 * time.Second)
+	}
+	// Calls were made at 0s, 10s and 20s, the window frees up at 60s.
+	if wait := l.reserve(); wait != 30*time.Second {
+		t.Errorf("reserve() over the limit returned wait %s, want 30s", wait)
+	}
+	c.Advance(30 * time.Second)
+	if wait := l.reserve(); wait != 0 {
+		t.Errorf("reserve() after the oldest call left the window returned wait %s, want 0", wait)
+	}
+	if wait := l.reserve(); wait != 10*time.Second {
+		t.Errorf("reserve() over the limit returned wait %s, want 10s", wait)
+	}
+}
+
+func TestRateLimiter_block(t *testing.T) {
+	c := newFakeClock()
+	l := newRateLimiter(c, 10, time.Minute)
+	l.block()
+	if wait := l.reserve(); wait != time.Minute {
+		t.Errorf("reserve() after block() returned wait %s, want 1m", wait)
+	}
+	c.Advance(time.Minute)
+	if wait := l.reserve(); wait != 0 {
+		t.Errorf("reserve() after block expired returned wait %s, want 0", wait)
+	}
+}
+
+func TestRateLimiter_unreserve(t *testing.T) {
+	c := newFakeClock()
+	l := newRateLimiter(c, 1, time.Minute)
+	l.reserve()
+	l.unreserve()
+	if wait := l.reserve(); wait != 0 {
+		t.Errorf("reserve() after unreserve() returned wait %s, want 0", wait)
+	}
+	if wait := l.reserve(); wait != time.Minute {
+		t.Errorf("reserve() over the limit returned wait %s, want 1m", wait)
+	}
+}
@@ -0,0 +1,104 @@
+package store
+
+import "fmt"
+
+// Query is the key of a query, e.g: an airport code or city name, along with the input row it was
+// read from.
+type Query struct {
+	Key string
+	// Row is the position of the query in the input, e.g: its dataset line.
+	Row int
+}
+
+// Result is the report of a query within a ResultSet.
+type Result struct {
+	Query
+	// Index is the position of the query among the queries of the set.
+	Index int
+	// First is the index of the first query with the same key, whose report is shared with every
+	// duplicate of it. It equals Index for first occurrences.
+	First  int
+	Report WeatherReport
+}
+
+// Duplicate reports whether the query repeats an earlier query of the set.
+func (r Result) Duplicate() bool {
+	return r.First != r.Index
+}
+
+// ResultSet holds the reports of a list of queries in the order the queries were given, unlike the
+// maps returned by Store which neither keep order nor duplicates. Duplicate queries share the report
+// of their first occurrence.
+type ResultSet struct {
+	results []Result
+	// first maps query keys to the index of their first occurrence.
+	first map[string]int
+	// rows maps query keys to the input rows of their occurrences.
+	rows map[string][]int
+}
+
+// NewResultSet returns the result set of the given queries, answered with reports as returned by a
+// Store. Queries without a report are reported as failed.
+func NewResultSet(queries []Query, reports map[string]WeatherReport) *ResultSet {
+	s := &ResultSet{results: make([]Result, len(queries)), first: make(map[string]int), rows: make(map[string][]int)}
+	for i, q := range queries {
+		first, ok := s.first[q.Key]
+		if !ok {
+			first = i
+			s.first[q.Key] = i
+		}
+		s.rows[q.Key] = append(s.rows[q.Key], q.Row)
+		r, ok := reports[q.Key]
+		if !ok {
+			r = WeatherReport{Failed: true, FailMessage: "missing from the store results"}
+		}
+		if first != i {
+			r.Provenance.SharedFrom = s.results[first].describe()
+		}
+		s.results[i] = Result{Query: q, Index: i, First: first, Report: r}
+	}
+	return s
+}
+
+// describe returns how the query is referred to by the duplicates sharing its report.
+func (r Result) describe() string {
+	if r.Row > 0 {
+		return fmt.Sprintf("row %d", r.Row)
+	}
+	return fmt.Sprintf("query %d", r.Index+1)
+}
+
+// Len returns the number of queries of the set, duplicates included.
+func (s *ResultSet) Len() int {
+	return len(s.results)
+}
+
+// At returns the result of the i-th query.
+func (s *ResultSet) At(i int) Result {
+	return s.results[i]
+}
+
+// Get returns the report of the query with the given key, if any.
+func (s *ResultSet) Get(key string) (WeatherReport, bool) {
+	i, ok := s.first[key]
+	if !ok {
+		return WeatherReport{}, false
+	}
+	return s.results[i].Report, true
+}
+
+// Unique returns the first occurrence of each query, in order.
+func (s *ResultSet) Unique() []Result {
+	unique := make([]Result, 0, len(s.first))
+	for _, r := range s.results {
+		if !r.Duplicate() {
+			unique = append(unique, r)
+		}
+	}
+	return unique
+}
+
+// Rows returns the input rows of every occurrence of the query with the given key, in order.
+func (s *ResultSet) Rows(key string) []int {
+	return append([]int(nil), s.rows[key]...)
+}
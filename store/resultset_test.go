@@ -0,0 +1,61 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResultSet(t *testing.T) {
+	queries := []Query{
+		{Key: "Toluca", Row: 2},
+		{Key: "Monterrey", Row: 3},
+		{Key: "Toluca", Row: 4},
+		{Key: "Atlantis", Row: 5},
+	}
+	reports := map[string]WeatherReport{
+		"Toluca":    {CityName: "Toluca"},
+		"Monterrey": {CityName: "Monterrey"},
+	}
+	s := NewResultSet(queries, reports)
+
+	var got []Result
+	for i := 0; i < s.Len(); i++ {
+		got = append(got, s.At(i))
+	}
+	missing := WeatherReport{Failed: true, FailMessage: "missing from the store results"}
+	duplicate := reports["Toluca"]
+	duplicate.Provenance.SharedFrom = "row 2"
+	want := []Result{
+		{Query: queries[0], Index: 0, First: 0, Report: reports["Toluca"]},
+		{Query: queries[1], Index: 1, First: 1, Report: reports["Monterrey"]},
+		{Query: queries[2], Index: 2, First: 0, Report: duplicate},
+		{Query: queries[3], Index: 3, First: 3, Report: missing},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("got results %v, want %v\ndiff: got->want %s", got, want, diff)
+	}
+	if diff := cmp.Diff(s.Unique(), []Result{want[0], want[1], want[3]}); diff != "" {
+		t.Errorf("Unique() returned unexpected results\ndiff: got->want %s", diff)
+	}
+	if got := s.Rows("Toluca"); !cmp.Equal(got, []int{2, 4}) {
+		t.Errorf("Rows(Toluca) = %v, want [2 4]", got)
+	}
+	if r, ok := s.Get("Monterrey"); !ok || r.CityName != "Monterrey" {
+		t.Errorf("Get(Monterrey) = %v, %t, want the Monterrey report", r, ok)
+	}
+	if _, ok := s.Get("Tampico"); ok {
+		t.Errorf("Get(Tampico) found a report, want none")
+	}
+}
+
+func TestResultSet_sharedWithoutRows(t *testing.T) {
+	s := NewResultSet([]Query{{Key: "MEX"}, {Key: "TLC"}, {Key: "MEX"}}, map[string]WeatherReport{"MEX": {}, "TLC": {}})
+
+	if got := s.At(2).Report.Provenance.SharedFrom; got != "query 1" {
+		t.Errorf("got duplicate shared from %q, want %q", got, "query 1")
+	}
+	if got := s.At(0).Report.Provenance.SharedFrom; got != "" {
+		t.Errorf("got first occurrence shared from %q, want none", got)
+	}
+}
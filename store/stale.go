@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StaleFallback is a Store decorator that looks up the stale reports of a store in another one,
+// e.g: an offline cache of previous runs, replacing them with the fallback reports that have more
+// recent observations. Replacements are flagged as stale when their observation is older than
+// staleAfter too.
+type StaleFallback struct {
+	store      Store
+	fallback   Store
+	clock      clock
+	staleAfter time.Duration
+}
+
+// NewStaleFallback returns a store answering stale reports of s from fallback when possible.
+func NewStaleFallback(s, fallback Store, staleAfter time.Duration) (*StaleFallback, error) {
+	return newStaleFallback(realClock{}, s, fallback, staleAfter)
+}
+
+func newStaleFallback(c clock, s, fallback Store, staleAfter time.Duration) (*StaleFallback, error) {
+	if staleAfter <= 0 {
+		return nil, fmt.Errorf("got invalid staleness threshold %s, want a positive duration", staleAfter)
+	}
+	return &StaleFallback{store: s, fallback: fallback, clock: c, staleAfter: staleAfter}, nil
+}
+
+// GetWeatherByAirportCode returns the weather report for the given airports, looking up the stale
+// ones in the fallback store.
+func (f *StaleFallback) GetWeatherByAirportCode(ctx context.Context, airports []Airport) map[string]WeatherReport {
+	data := f.store.GetWeatherByAirportCode(ctx, airports)
+	var stale []Airport
+	seen := make(map[string]bool)
+	for _, a := range airports {
+		if data[a.Code].Stale && !seen[a.Code] {
+			seen[a.Code] = true
+			stale = append(stale, a)
+		}
+	}
+	if len(stale) == 0 {
+		return data
+	}
+	return f.replace(data, f.fallback.GetWeatherByAirportCode(ctx, stale))
+}
+
+// GetWeatherByCityName returns the weather report for each city name, looking up the stale ones in
+// the fallback store.
+func (f *StaleFallback) GetWeatherByCityName(ctx context.Context, cities []string) map[string]WeatherReport {
+	data := f.store.GetWeatherByCityName(ctx, cities)
+	var stale []string
+	for k, r := range data {
+		if r.Stale {
+			stale = append(stale, k)
+		}
+	}
+	if len(stale) == 0 {
+		return data
+	}
+	return f.replace(data, f.fallback.GetWeatherByCityName(ctx, stale))
+}
+
+// replace the reports in data with the successful fallback ones observed more recently.
+func (f *StaleFallback) replace(data, fallback map[string]WeatherReport) map[string]WeatherReport {
+	now := f.clock.Now()
+	for k, r := range fallback {
+		if r.Failed || !r.ObservationTime.After(data[k].ObservationTime) {
+			continue
+		}
+		r.Stale = isStale(r, now, f.staleAfter)
+		data[k] = r
+	}
+	return data
+}
+
+// isStale tells whether the observation of r is older than staleAfter at now, a zero staleAfter
+// disabling the check. Failed reports are never stale.
+func isStale(r WeatherReport, now time.Time, staleAfter time.Duration) bool {
+	return !r.Failed && staleAfter > 0 && now.Sub(r.ObservationTime) > staleAfter
+}
+
+// GetAPIUsage returns the decorated store API usage statistics.
+func (f *StaleFallback) GetAPIUsage() APIUsage {
+	return f.store.GetAPIUsage()
+}
+
+// PlanWeatherByAirportCode returns the plan of the decorated store, if it supports dry runs.
+func (f *StaleFallback) PlanWeatherByAirportCode(airports []Airport) Plan {
+	if planner, ok := f.store.(Planner); ok {
+		return planner.PlanWeatherByAirportCode(airports)
+	}
+	return Plan{}
+}
+
+// PlanWeatherByCityName returns the plan of the decorated store, if it supports dry runs.
+func (f *StaleFallback) PlanWeatherByCityName(cities []string) Plan {
+	if planner, ok := f.store.(Planner); ok {
+		return planner.PlanWeatherByCityName(cities)
+	}
+	return Plan{}
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+// agingAPI answers the n-th call for a city with an observation made ages[n] before the current
+// time of clock, repeating the last age once they run out.
+type agingAPI struct {
+	clock clock
+	ages  map[string][]time.Duration
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (a *agingAPI) GetWeatherByCoords(_, _ float64) (*openweather.WeatherItem, error) {
+	return a.GetWeatherByCityName("")
+}
+
+func (a *agingAPI) GetWeatherByCityName(cityName string) (*openweather.WeatherItem, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ages := a.ages[cityName]
+	n := a.calls[cityName]
+	if n >= len(ages) {
+		n = len(ages) - 1
+	}
+	a.calls[cityName]++
+	item := fixedWeatherResponse
+	item.CityName = cityName
+	item.ObservationTime = int(a.clock.Now().Add(-ages[n]).Unix())
+	return &item, nil
+}
+
+func TestConcurrentStore_Stale(t *testing.T) {
+	ages := map[string][]time.Duration{
+		"Toluca":    {5 * time.Minute},
+		"Monterrey": {3 * time.Hour, 2 * time.Hour, 10 * time.Minute},
+		"Tampico":   {3 * time.Hour, 4 * time.Hour},
+	}
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantStale map[string]bool
+		wantAge   map[string]time.Duration
+		wantCalls map[string]int
+	}{
+		{
+			name:      "no threshold",
+			wantStale: map[string]bool{"Toluca": false, "Monterrey": false, "Tampico": false},
+			wantAge:   map[string]time.Duration{"Toluca": 5 * time.Minute, "Monterrey": 3 * time.Hour, "Tampico": 3 * time.Hour},
+			wantCalls: map[string]int{"Toluca": 1, "Monterrey": 1, "Tampico": 1},
+		},
+		{
+			name:      "old observations are flagged",
+			opts:      []Option{WithStaleAfter(time.Hour)},
+			wantStale: map[string]bool{"Toluca": false, "Monterrey": true, "Tampico": true},
+			wantAge:   map[string]time.Duration{"Toluca": 5 * time.Minute, "Monterrey": 3 * time.Hour, "Tampico": 3 * time.Hour},
+			wantCalls: map[string]int{"Toluca": 1, "Monterrey": 1, "Tampico": 1},
+		},
+		{
+			name:      "stale reports are refetched",
+			opts:      []Option{WithStaleAfter(time.Hour), WithStaleRefetch(1)},
+			wantStale: map[string]bool{"Toluca": false, "Monterrey": true, "Tampico": true},
+			wantAge:   map[string]time.Duration{"Toluca": 5 * time.Minute, "Monterrey": 2 * time.Hour, "Tampico": 3 * time.Hour},
+			wantCalls: map[string]int{"Toluca": 1, "Monterrey": 2, "Tampico": 2},
+		},
+		{
+			name:      "stale reports are refetched until fresh",
+			opts:      []Option{WithStaleAfter(time.Hour), WithStaleRefetch(5)},
+			wantStale: map[string]bool{"Toluca": false, "Monterrey": false, "Tampico": true},
+			wantAge:   map[string]time.Duration{"Toluca": 5 * time.Minute, "Monterrey": 10 * time.Minute, "Tampico": 3 * time.Hour},
+			wantCalls: map[string]int{"Toluca": 1, "Monterrey": 3, "Tampico": 6},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClock()
+			api := &agingAPI{clock: c, ages: ages, calls: make(map[string]int)}
+			s := NewConcurrentStore(api, append(test.opts, withClock(c))...)
+
+			got := s.GetWeatherByCityName(context.Background(), []string{"Toluca", "Monterrey", "Tampico"})
+			gotStale := make(map[string]bool)
+			gotAge := make(map[string]time.Duration)
+			for k, r := range got {
+				gotStale[k] = r.Stale
+				gotAge[k] = c.Now().Sub(r.ObservationTime)
+			}
+			if diff := cmp.Diff(gotStale, test.wantStale); diff != "" {
+				t.Errorf("got stale %v, want %v\ndiff: got->want %s", gotStale, test.wantStale, diff)
+			}
+			if diff := cmp.Diff(gotAge, test.wantAge); diff != "" {
+				t.Errorf("got observation ages %v, want %v\ndiff: got->want %s", gotAge, test.wantAge, diff)
+			}
+			if diff := cmp.Diff(api.calls, test.wantCalls); diff != "" {
+				t.Errorf("got calls %v, want %v\ndiff: got->want %s", api.calls, test.wantCalls, diff)
+			}
+		})
+	}
+}
+
+// reportsStore is a Store answering queries with the given reports, queries without one fail.
+type reportsStore struct {
+	reports map[string]WeatherReport
+	// queried holds the keys of every query received.
+	queried []string
+}
+
+func (s *reportsStore) GetWeatherByAirportCode(ctx context.Context, airports []Airport) map[string]WeatherReport {
+	return s.GetWeatherByCityName(ctx, airportCodes(airports))
+}
+
+func (s *reportsStore) GetWeatherByCityName(_ context.Context, keys []string) map[string]WeatherReport {
+	data := make(map[string]WeatherReport)
+	for _, k := range keys {
+		s.queried = append(s.queried, k)
+		r, ok := s.reports[k]
+		if !ok {
+			r = WeatherReport{Failed: true, FailMessage: "not cached"}
+		}
+		data[k] = r
+	}
+	return data
+}
+
+func (s *reportsStore) GetAPIUsage() APIUsage {
+	return APIUsage{}
+}
+
+func TestNewStaleFallback(t *testing.T) {
+	if _, err := NewStaleFallback(&reportsStore{}, &reportsStore{}, 0); err == nil {
+		t.Errorf("NewStaleFallback(0) returned nil error, want error")
+	}
+}
+
+func TestStaleFallback(t *testing.T) {
+	c := newFakeClock()
+	observed := func(ago time.Duration) time.Time {
+		return c.Now().Add(-ago)
+	}
+	primary := &reportsStore{reports: map[string]WeatherReport{
+		"Toluca":    {CityName: "Toluca", ObservationTime: observed(time.Minute)},
+		"Monterrey": {CityName: "Monterrey", ObservationTime: observed(3 * time.Hour), Stale: true},
+		"Tampico":   {CityName: "Tampico", ObservationTime: observed(3 * time.Hour), Stale: true},
+		"Puebla":    {CityName: "Puebla", ObservationTime: observed(3 * time.Hour), Stale: true},
+		"Cancun":    {CityName: "Cancun", ObservationTime: observed(3 * time.Hour), Stale: true},
+	}}
+	fallback := &reportsStore{reports: map[string]WeatherReport{
+		"Toluca":    {CityName: "Toluca (fallback)", ObservationTime: observed(0)},
+		"Monterrey": {CityName: "Monterrey (fallback)", ObservationTime: observed(10 * time.Minute), Age: 5 * time.Minute},
+		"Tampico":   {CityName: "Tampico (fallback)", ObservationTime: observed(2 * time.Hour)},
+		"Puebla":    {CityName: "Puebla (fallback)", ObservationTime: observed(4 * time.Hour)},
+	}}
+	s, err := newStaleFallback(c, primary, fallback, time.Hour)
+	if err != nil {
+		t.Fatalf("newStaleFallback() returned unexpected error: %v", err)
+	}
+
+	got := s.GetWeatherByCityName(context.Background(), []string{"Toluca", "Monterrey", "Tampico", "Puebla", "Cancun"})
+	want := map[string]WeatherReport{
+		"Toluca":    primary.reports["Toluca"],
+		"Monterrey": fallback.reports["Monterrey"],
+		"Tampico":   {CityName: "Tampico (fallback)", ObservationTime: observed(2 * time.Hour), Stale: true},
+		"Puebla":    primary.reports["Puebla"],
+		"Cancun":    primary.reports["Cancun"],
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("got %v, want %v\ndiff: got->want %s", got, want, diff)
+	}
+	if len(fallback.queried) != 4 {
+		t.Errorf("fallback store was queried for %v, want only the stale reports", fallback.queried)
+	}
+}
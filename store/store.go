@@ -2,18 +2,26 @@
 // services.
 package store
 
-import "time"
+import (
+	"context"
+	"time"
+
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
 
 // Store exposes a series of methods for querying weather information of specific cities.
 // It abstracts away cache layer and API access.
+//
+// Cancelling the context passed to a query stops it from performing further API calls, queries
+// left unanswered are reported as failed.
 type Store interface {
 	// GetWeatherReport returns the weather report for the given airports on the current date and time.
 	// The returned map contains the airport code as the key and a weather report instance as value.
-	GetWeatherByAirportCode([]Airport) map[string]WeatherReport
+	GetWeatherByAirportCode(context.Context, []Airport) map[string]WeatherReport
 
 	// GetWeatherByCityName returns the weather report for each city name. The returned map contains
 	// the city name as key and a weather report instance as value.
-	GetWeatherByCityName([]string) map[string]WeatherReport
+	GetWeatherByCityName(context.Context, []string) map[string]WeatherReport
 
 	// GetAPIUsage returns OpenWeather API usage statistics.
 	GetAPIUsage() APIUsage
@@ -37,24 +45,93 @@ type WeatherReport struct {
 	Lon float64
 	// Description is a human readable set of weather descriptions
 	Description []string
+	// Details is the detailed description of each weather condition localized to Language.
+	Details []string
+	// Language of Details, empty for the API default language (English).
+	Language string
 	// CityName is the city name registered in the API dataset for the weather observation.
 	CityName string
-	// Temperature in celsius with two decimals precision.
+	// Units system of temperatures and wind speed.
+	Units openweather.Units
+	// Temperature in Units with two decimals precision.
 	Temp float64 `json:"temp"`
 	// MaxTemp is the maximum expected temperature for the observation time.
 	MaxTemp float64 `json:"temp_max"`
 	// MinTemp is the maximum expected temperature for the observation time.
 	MinTemp float64 `json:"temp_min"`
-	// FeelsLike in celsius.
+	// FeelsLike in Units.
 	FeelsLike float64 `json:"feels_like"`
 	// Humidity percentage.
 	Humidity int `json:"humidity"`
+	// WindSpeed in Units, i.e: meter/sec for standard and metric, miles/hour for imperial.
+	WindSpeed float64
 	// ObservationTime when the weather was measured.
 	ObservationTime time.Time
 	// Failed indicates that the API request was unsuccessful
 	Failed bool
 	// FailMessage is the reason of failure.
 	FailMessage string
+	// NotAttempted indicates the query failed because it was never sent to the API, e.g: the run
+	// was interrupted before getting to it.
+	NotAttempted bool
+	// NotFound indicates the query failed because the API doesn't know the requested location.
+	NotFound bool
+	// NotCached indicates the query failed because it was answered offline and was never cached.
+	NotCached bool
+	// Stale indicates the observation was older than the store staleness threshold when answered,
+	// see WithStaleAfter and WithCacheStaleAfter.
+	Stale bool
+	// Age is how long ago the report was fetched from the API, set when answered from a cache.
+	Age time.Duration
+	// Provenance describes where and when the report was obtained.
+	Provenance Provenance
+}
+
+// CacheStatus tells whether a report was answered from a cache.
+type CacheStatus string
+
+const (
+	// CacheHit reports were answered from a cache.
+	CacheHit CacheStatus = "hit"
+	// CacheMiss reports were looked up in a cache but had to be fetched.
+	CacheMiss CacheStatus = "miss"
+)
+
+// Provenance describes where a report comes from. Fields are set by the store that fetched the
+// report and by the decorators it went through, unset fields are unknown or don't apply, e.g: the
+// cache status of a report from a store without cache.
+type Provenance struct {
+	// Provider is the name of the weather data provider, e.g: OpenWeather.
+	Provider string
+	// Endpoint is the URL of the API endpoint the report was obtained from, without query parameters.
+	Endpoint string
+	// FetchedAt is when the API call that produced the report completed.
+	FetchedAt time.Time
+	// Latency is how long the API call took, retries included.
+	Latency time.Duration
+	// Attempts is the number of API calls performed, e.g: retries with other API keys.
+	Attempts int
+	// Cache tells whether the report was answered from a cache.
+	Cache CacheStatus
+	// SharedFrom is the query whose API call produced the report when it was deduplicated with it:
+	// either the same query of another fetch in progress, e.g: "city Toluca of fetch 3", or its first
+	// occurrence within a ResultSet, e.g: "row 2".
+	SharedFrom string
+}
+
+// ConvertTo returns a copy of the report with temperatures and wind speed expressed in units u.
+// Failed reports and reports with unknown units are returned unchanged.
+func (r WeatherReport) ConvertTo(u openweather.Units) WeatherReport {
+	if r.Failed || r.Units == "" || r.Units == u {
+		return r
+	}
+	r.Temp = openweather.ConvertTemperature(r.Temp, r.Units, u)
+	r.MaxTemp = openweather.ConvertTemperature(r.MaxTemp, r.Units, u)
+	r.MinTemp = openweather.ConvertTemperature(r.MinTemp, r.Units, u)
+	r.FeelsLike = openweather.ConvertTemperature(r.FeelsLike, r.Units, u)
+	r.WindSpeed = openweather.ConvertSpeed(r.WindSpeed, r.Units, u)
+	r.Units = u
+	return r
 }
 
 // APIUsage contains usage statistics.
@@ -63,4 +140,18 @@ type APIUsage struct {
 	SuccessfulCalls uint
 	// FailedCalls count.
 	FailedCalls uint
+	// ShortCircuited count, i.e: queries failed right away by an open circuit breaker, without
+	// calling the API. They are not counted as failed calls.
+	ShortCircuited uint
+	// CoalescedRequests count, i.e: queries answered with the result of an identical call already
+	// in flight for another caller instead of calling the API again.
+	CoalescedRequests uint
+	// RequestsPerMinute is the current rate of API calls, lowered when the API reports it is
+	// rate-limiting or failing and slowly raised back on success.
+	RequestsPerMinute int
+	// Cache contains cache statistics when the store is decorated with a CachedStore.
+	Cache *CacheStats
+	// Keys contains per-key usage statistics when the store spreads calls across several API keys,
+	// e.g: using a KeyPool.
+	Keys []KeyUsage
 }
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/pablotrinidad/weatherreport/store/openweather"
+)
+
+func TestWeatherReport_ConvertTo(t *testing.T) {
+	tests := []struct {
+		name   string
+		report WeatherReport
+		units  openweather.Units
+		want   WeatherReport
+	}{
+		{
+			name:   "same units",
+			report: WeatherReport{Units: openweather.Metric, Temp: 20, WindSpeed: 2},
+			units:  openweather.Metric,
+			want:   WeatherReport{Units: openweather.Metric, Temp: 20, WindSpeed: 2},
+		},
+		{
+			name:   "metric to imperial",
+			report: WeatherReport{Units: openweather.Metric, Temp: 100, MaxTemp: 0, MinTemp: -40, FeelsLike: 37, WindSpeed: 0.44704},
+			units:  openweather.Imperial,
+			want:   WeatherReport{Units: openweather.Imperial, Temp: 212, MaxTemp: 32, MinTemp: -40, FeelsLike: 98.6, WindSpeed: 1},
+		},
+		{
+			name:   "imperial to standard",
+			report: WeatherReport{Units: openweather.Imperial, Temp: 32, MaxTemp: 32, MinTemp: 32, FeelsLike: 32, WindSpeed: 1},
+			units:  openweather.Standard,
+			want:   WeatherReport{Units: openweather.Standard, Temp: 273.15, MaxTemp: 273.15, MinTemp: 273.15, FeelsLike: 273.15, WindSpeed: 0.44704},
+		},
+		{
+			name:   "failed report",
+			report: WeatherReport{Failed: true, FailMessage: "boom"},
+			units:  openweather.Imperial,
+			want:   WeatherReport{Failed: true, FailMessage: "boom"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.report.ConvertTo(test.units)
+			if diff := cmp.Diff(got, test.want, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+				t.Errorf("ConvertTo(%s): %v, want %v\ngot -> want diff: %s", test.units, got, test.want, diff)
+			}
+		})
+	}
+}